@@ -0,0 +1,154 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// CodeBuilder defines a code along with all of its meta data.
+// Nothing is registered until Build, which registers the code and sets its meta data together:
+// if any piece conflicts, nothing is registered.
+//
+//	var ExistsCode = errcode.NewCodeBuilder("state.exists").
+//		Parent(errcode.StateCode).
+//		HTTP(http.StatusConflict).
+//		MetaData(grpc.CodeMetaData(codes.AlreadyExists)).
+//		Retryable(false).
+//		Description("the resource already exists").
+//		Build()
+type CodeBuilder struct {
+	codeStr  CodeStr
+	parent   *Code
+	metaData []builderMetaData
+}
+
+type builderMetaData struct {
+	name     string
+	metaData MetaData
+	item     interface{}
+	// check validates the item for a code
+	check func(CodeStr) error
+}
+
+// NewCodeBuilder starts the definition of a code.
+// Like Child, the codeStr of a code with a Parent is its full CodeStr.
+func NewCodeBuilder(codeStr CodeStr) *CodeBuilder {
+	return &CodeBuilder{codeStr: codeStr}
+}
+
+// Parent sets the parent of the code.
+// Without a Parent a top-level code is built, the same as NewCode.
+func (b *CodeBuilder) Parent(parent Code) *CodeBuilder {
+	b.parent = &parent
+	return b
+}
+
+// MetaData adds meta data to set for the code.
+// This is used to implement the other meta data steps and by packages with their own meta data,
+// for example grpc.CodeMetaData.
+func (b *CodeBuilder) MetaData(metaData MetaData, item interface{}) *CodeBuilder {
+	return b.addMetaData("MetaData", metaData, item)
+}
+
+// HTTP sets the HTTP code, the same as SetHTTP.
+// Build fails if the HTTP code is not a valid status.
+func (b *CodeBuilder) HTTP(httpCode int) *CodeBuilder {
+	b.addMetaData("HTTP", httpMetaData, httpCode)
+	b.metaData[len(b.metaData)-1].check = func(codeStr CodeStr) error {
+		return checkHTTPCode(codeStr, httpCode)
+	}
+	return b
+}
+
+// Retryable sets the retryable flag, the same as SetRetryable.
+func (b *CodeBuilder) Retryable(retryable bool) *CodeBuilder {
+	return b.addMetaData("Retryable", retryableMetaData, retryable)
+}
+
+// Description sets the description, the same as SetDescription.
+func (b *CodeBuilder) Description(description string) *CodeBuilder {
+	return b.addMetaData("Description", descriptionMetaData, description)
+}
+
+func (b *CodeBuilder) addMetaData(name string, metaData MetaData, item interface{}) *CodeBuilder {
+	b.metaData = append(b.metaData, builderMetaData{name: name, metaData: metaData, item: item})
+	return b
+}
+
+type conflictingMetaDataError struct {
+	name    string
+	codeStr CodeStr
+}
+
+func (e conflictingMetaDataError) Error() string {
+	return fmt.Sprintf("for code %v %v is set more than once", e.codeStr, e.name)
+}
+
+// TryBuild registers the code and sets all of its meta data.
+// An error is returned if the code is invalid, is already registered,
+// or any of its meta data is invalid, already set, or given more than once.
+// In that case nothing is registered or set.
+func (b *CodeBuilder) TryBuild() (Code, error) {
+	code := Code{codeStr: b.codeStr, Parent: b.parent}
+	if err := code.checkCodePath(); err != nil {
+		return Code{}, err
+	}
+	if code.Parent != nil {
+		// Don't store parent paths, those are re-constructed in CodeStr()
+		paths := strings.Split(code.codeStr.String(), ".")
+		code.codeStr = CodeStr(paths[len(paths)-1])
+	}
+	codeStr := code.CodeStr()
+
+	metaDataLock.Lock()
+	defer metaDataLock.Unlock()
+	seen := make(map[string]bool, len(b.metaData))
+	for _, md := range b.metaData {
+		if md.check != nil {
+			if err := md.check(codeStr); err != nil {
+				return Code{}, err
+			}
+		}
+		if existing, ok := md.metaData[codeStr]; ok {
+			return Code{}, errors.Annotate(existingCodeError{existingMetaData: existing, code: code}, md.name)
+		}
+		// a MetaData is a map, so compare it by its pointer
+		key := fmt.Sprintf("%p", md.metaData)
+		if seen[key] {
+			return Code{}, conflictingMetaDataError{name: md.name, codeStr: codeStr}
+		}
+		seen[key] = true
+	}
+	if err := code.register(); err != nil {
+		return Code{}, err
+	}
+	for _, md := range b.metaData {
+		md.metaData[codeStr] = md.item
+	}
+	metaDataChangedLocked()
+	return code, nil
+}
+
+// Build is the same as TryBuild but panics on an error, the same as NewCode and the meta data setters.
+func (b *CodeBuilder) Build() Code {
+	code, err := b.TryBuild()
+	if err != nil {
+		panic(errors.Annotate(err, "Build"))
+	}
+	return code
+}
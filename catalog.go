@@ -0,0 +1,103 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// CatalogEntry documents a registered code.
+// It is designed to be serialized to JSON for generating API documentation.
+// Parent is empty for a top-level code.
+// Fields holds the values of fields added by other packages with RegisterCatalogField:
+// for example importing the grpc package adds the "grpc" field.
+type CatalogEntry struct {
+	Code        CodeStr           `json:"code"`
+	Parent      CodeStr           `json:"parent,omitempty"`
+	HTTP        int               `json:"http"`
+	Retryable   bool              `json:"retryable"`
+	Description string            `json:"description,omitempty"`
+	Example     interface{}       `json:"example,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+}
+
+var (
+	catalogFieldsLock sync.RWMutex
+	catalogFields     = make(map[string]func(Code) string)
+)
+
+// RegisterCatalogField adds a field to the Fields of every CatalogEntry.
+// This lets packages that attach their own meta data to codes (such as the grpc package) document it.
+// An empty value is not added to the Fields of an entry.
+// Registering a name again replaces the field.
+func RegisterCatalogField(name string, field func(Code) string) {
+	catalogFieldsLock.Lock()
+	defer catalogFieldsLock.Unlock()
+	catalogFields[name] = field
+}
+
+// ExportCatalog gives a CatalogEntry for every registered code (see RegisteredCodes).
+// The entries are sorted by CodeStr, and encoding/json sorts the keys of Fields,
+// so the JSON of the catalog is deterministic.
+func ExportCatalog() []CatalogEntry {
+	catalogFieldsLock.RLock()
+	defer catalogFieldsLock.RUnlock()
+	names := make([]string, 0, len(catalogFields))
+	for name := range catalogFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	codes := registeredCodesWhere(func(Code) bool { return true })
+	entries := make([]CatalogEntry, len(codes))
+	for i, code := range codes {
+		entry := CatalogEntry{
+			Code:        code.CodeStr(),
+			HTTP:        code.HTTPCode(),
+			Retryable:   code.IsRetryable(),
+			Description: code.Description(),
+			Example:     code.Example(),
+		}
+		if code.Parent != nil {
+			entry.Parent = code.Parent.CodeStr()
+		}
+		for _, name := range names {
+			if value := catalogFields[name](code); value != "" {
+				if entry.Fields == nil {
+					entry.Fields = make(map[string]string)
+				}
+				entry.Fields[name] = value
+			}
+		}
+		entries[i] = entry
+	}
+	return entries
+}
+
+// GenerateCatalog gives a CatalogEntry for every registered code for documentation tooling,
+// which can render the entries as JSON or markdown.
+// Each entry has the Description of its code (see SetDescription).
+// It is the same as ExportCatalog.
+func GenerateCatalog() []CatalogEntry {
+	return ExportCatalog()
+}
+
+// DumpCatalog gives the indented JSON of ExportCatalog.
+// Two dumps of the same codes and meta data are byte-identical,
+// so a dump can be checked in to detect accidental changes to codes in code review.
+func DumpCatalog() ([]byte, error) {
+	return json.MarshalIndent(ExportCatalog(), "", "  ")
+}
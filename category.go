@@ -0,0 +1,61 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+// Category is a small fixed set of error kinds that clients can switch on
+// without parsing the hierarchical CodeStr.
+// It is derived from the root of a code (see Code.Category).
+type Category string
+
+// The categories. CategoryUnknown is given for codes whose root has no category.
+const (
+	CategoryUnknown   Category = ""
+	CategoryAuth      Category = "auth"
+	CategoryInput     Category = "input"
+	CategoryState     Category = "state"
+	CategoryNotFound  Category = "notfound"
+	CategoryInternal  Category = "internal"
+	CategoryTimeout   Category = "timeout"
+	CategoryRateLimit Category = "ratelimit"
+)
+
+// rootCategories maps the CodeStr of a root code to its Category.
+// UnavailableCode is a server side failure and so is categorized with InternalCode.
+var rootCategories = map[CodeStr]Category{
+	"auth":        CategoryAuth,
+	"input":       CategoryInput,
+	"state":       CategoryState,
+	"missing":     CategoryNotFound,
+	"internal":    CategoryInternal,
+	"unavailable": CategoryInternal,
+	"timeout":     CategoryTimeout,
+	"ratelimit":   CategoryRateLimit,
+}
+
+func (category Category) String() string {
+	if category == CategoryUnknown {
+		return "unknown"
+	}
+	return string(category)
+}
+
+// Category gives the Category of the root ancestor of the code.
+// If the root has no category, CategoryUnknown is returned.
+func (code Code) Category() Category {
+	root := code
+	for root.Parent != nil {
+		root = *root.Parent
+	}
+	return rootCategories[root.CodeStr()]
+}
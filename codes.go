@@ -14,19 +14,23 @@
 package errcode
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 )
 
 var (
 	// InternalCode is equivalent to HTTP 500 Internal Server Error.
-	InternalCode = NewCode("internal").SetHTTP(http.StatusInternalServerError)
+	// Responses should not be cached.
+	// The operation may be retried.
+	InternalCode = NewCode("internal").SetHTTP(http.StatusInternalServerError).SetNoCache(true).SetRetryable(true).SetSeverity(SeverityError)
 
 	// NotFoundCode is equivalent to HTTP 404 Not Found.
-	NotFoundCode = NewCode("missing").SetHTTP(http.StatusNotFound)
+	NotFoundCode = NewCode("missing").SetHTTP(http.StatusNotFound).SetSeverity(SeverityInfo)
 
 	// UnimplementedCode is mapped to HTTP 501.
-	UnimplementedCode = InternalCode.Child("internal.unimplemented").SetHTTP(http.StatusNotImplemented)
+	// Unlike other internal codes, retrying will not help.
+	UnimplementedCode = InternalCode.Child("internal.unimplemented").SetHTTP(http.StatusNotImplemented).SetRetryable(false)
 
 	// StateCode is an error that is invalid due to the current system state.
 	// This operatiom could become valid if the system state changes
@@ -37,12 +41,25 @@ var (
 	// This is mapped to HTTP 409.
 	AlreadyExistsCode = StateCode.Child("state.exists").SetHTTP(http.StatusConflict)
 
+	// IdempotencyConflictCode indicates an idempotency key was reused with a different request payload.
+	// A request that reuses an idempotency key with the same payload is not an error:
+	// the result of the original request should be returned instead.
+	// This is mapped to HTTP 422.
+	IdempotencyConflictCode = AlreadyExistsCode.Child("state.exists.idempotency").SetHTTP(http.StatusUnprocessableEntity)
+
 	// OutOfRangeCode indicates an operation was attempted past a valid range.
 	// This is mapped to HTTP 400.
 	OutOfRangeCode = StateCode.Child("state.range")
 
+	// DegradedCode indicates the service handled the request with reduced functionality.
+	// Unlike UnavailableCode, the response is still useful.
+	// Unusually for an error, this is mapped to a success status: HTTP 203 Non-Authoritative Information.
+	// This lets a client use the response while knowing it is incomplete.
+	DegradedCode = StateCode.Child("state.degraded").SetHTTP(http.StatusNonAuthoritativeInfo)
+
 	// InvalidInputCode is equivalent to HTTP 400 Bad Request.
-	InvalidInputCode = NewCode("input").SetHTTP(http.StatusBadRequest)
+	// Retrying with the same input will not help.
+	InvalidInputCode = NewCode("input").SetHTTP(http.StatusBadRequest).SetRetryable(false)
 
 	// AuthCode represents an authentication or authorization issue.
 	AuthCode = NewCode("auth")
@@ -52,11 +69,71 @@ var (
 	// Note that HTTP 401 is poorly named "Unauthorized".
 	NotAuthenticatedCode = AuthCode.Child("auth.unauthenticated").SetHTTP(http.StatusUnauthorized)
 
+	// TokenExpiredCode indicates the credentials of the user have expired.
+	// The client should refresh its token rather than ask the user to log in again.
+	// This inherits HTTP 401 from NotAuthenticatedCode.
+	TokenExpiredCode = NotAuthenticatedCode.Child("auth.unauthenticated.expired")
+
+	// InvalidCredentialsCode indicates the credentials of the user are not valid, for example a bad token signature.
+	// Refreshing will not help: the user must log in again.
+	// This inherits HTTP 401 from NotAuthenticatedCode.
+	InvalidCredentialsCode = NotAuthenticatedCode.Child("auth.unauthenticated.invalid")
+
 	// ForbiddenCode indicates the user is not authorized.
 	// This is mapped to HTTP 403.
 	ForbiddenCode = AuthCode.Child("auth.forbidden").SetHTTP(http.StatusForbidden)
+
+	// PaymentRequiredCode indicates the user must pay (or upgrade) before access is allowed.
+	// This is mapped to HTTP 402.
+	PaymentRequiredCode = ForbiddenCode.Child("auth.forbidden.payment").SetHTTP(http.StatusPaymentRequired)
+
+	// TimeoutCode indicates an operation did not complete before its deadline.
+	// This is mapped to HTTP 504.
+	// Responses should not be cached.
+	TimeoutCode = NewCode("timeout").SetHTTP(http.StatusGatewayTimeout).SetNoCache(true)
+
+	// UnavailableCode indicates the service is temporarily unable to handle the request.
+	// The request can be tried again later.
+	// This is not an InternalCode so that it is not replaced by NewInternalErr.
+	// This is mapped to HTTP 503.
+	// Responses should not be cached.
+	// The operation may be retried.
+	UnavailableCode = NewCode("unavailable").SetHTTP(http.StatusServiceUnavailable).SetNoCache(true).SetRetryable(true)
+
+	// RateLimitCode indicates the client sent too many requests.
+	// This is mapped to HTTP 429.
+	// Responses should not be cached.
+	// The operation may be retried after backing off.
+	RateLimitCode = NewCode("ratelimit").SetHTTP(http.StatusTooManyRequests).SetNoCache(true).SetRetryable(true)
 )
 
+// httpCodes maps an HTTP status to the built-in code that best represents it.
+var httpCodes = map[int]Code{
+	http.StatusUnauthorized:       NotAuthenticatedCode,
+	http.StatusPaymentRequired:    PaymentRequiredCode,
+	http.StatusForbidden:          ForbiddenCode,
+	http.StatusNotFound:           NotFoundCode,
+	http.StatusRequestTimeout:     TimeoutCode,
+	http.StatusConflict:           AlreadyExistsCode,
+	http.StatusTooManyRequests:    RateLimitCode,
+	http.StatusNotImplemented:     UnimplementedCode,
+	http.StatusServiceUnavailable: UnavailableCode,
+	http.StatusGatewayTimeout:     TimeoutCode,
+}
+
+// CodeForHTTP gives the built-in code that best represents an HTTP status.
+// This is useful for turning an HTTP response from an upstream service into an ErrorCode.
+// A status without a specific code gives InternalCode for 5xx and InvalidInputCode otherwise.
+func CodeForHTTP(status int) Code {
+	if code, ok := httpCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return InternalCode
+	}
+	return InvalidInputCode
+}
+
 // invalidInput gives the code InvalidInputCode.
 type invalidInputErr struct{ CodedError }
 
@@ -80,32 +157,95 @@ var internalStackCode = makeInternalStackCode(InternalCode)
 // If the given err is an ErrorCode that is a descendant of InternalCode,
 // its code will be used.
 // This ensures the intention of sending an HTTP 50x.
-// This function also records a stack trace.
+// This function also records a stack trace starting at the caller of NewInternalErr.
+// The client data is InternalClientData and the user message (see UserMsg) is the HTTP status text,
+// so the message of err is not sent to the client.
 func NewInternalErr(err error) ErrorCode {
-	return internalErr{internalStackCode(err)}
+	return internalErr{internalStackCode(err, 0)}
+}
+
+// NewInternalErrDepth is the same as NewInternalErr but removes skip more callers from the stack trace.
+// This is for use in your own constructors that wrap NewInternalErr:
+// a constructor that calls NewInternalErrDepth(err, 1) records a stack starting at its caller.
+func NewInternalErrDepth(err error, skip int) ErrorCode {
+	return internalErr{internalStackCode(err, skip)}
+}
+
+// InternalClientData is the client data of the errors created by NewInternalErr and NewUnimplementedErr.
+// The message of the cause is never sent to the client: Msg is the generic HTTP status text.
+// The cause is still available for logging through Error() and Cause().
+type InternalClientData struct {
+	Code CodeStr `json:"code"`
+	Msg  string  `json:"msg"`
+}
+
+func internalClientData(code Code) InternalClientData {
+	return InternalClientData{Code: code.CodeStr(), Msg: http.StatusText(code.HTTPStatus())}
+}
+
+// GetClientData returns InternalClientData so that the cause is not leaked to the client.
+func (e internalErr) GetClientData() interface{} {
+	return internalClientData(e.Code())
+}
+
+// GetUserMsg returns the generic HTTP status text so that UserMsg does not fall back to the message of the cause.
+func (e internalErr) GetUserMsg() string {
+	return internalClientData(e.Code()).Msg
 }
 
 var _ ErrorCode = (*internalErr)(nil)     // assert implements interface
 var _ HasClientData = (*internalErr)(nil) // assert implements interface
+var _ HasUserMsg = (*internalErr)(nil)    // assert implements interface
 var _ Causer = (*internalErr)(nil)        // assert implements interface
 
+// Coerce normalizes any error into an ErrorCode so that responders handle all errors the same way.
+// An ErrorCode is returned unchanged.
+// If the error wraps an ErrorCode, the result of CodeChain is returned.
+// Otherwise it is wrapped with NewInternalErr, recording a stack trace starting at the caller of Coerce.
+// A nil error gives nil.
+// This is used by grpc.WrapAsGRPC.
+func Coerce(err error) ErrorCode {
+	return CoerceDepth(err, 1)
+}
+
+// CoerceDepth is the same as Coerce but removes skip more callers from the stack trace.
+// This is for use in your own responders that wrap Coerce:
+// a responder that calls CoerceDepth(err, 1) records a stack starting at its caller.
+func CoerceDepth(err error, skip int) ErrorCode {
+	if err == nil {
+		return nil
+	}
+	if errCode, ok := err.(ErrorCode); ok {
+		return errCode
+	}
+	if errCode := CodeChain(err); errCode != nil {
+		return errCode
+	}
+	return NewInternalErrDepth(err, 1+skip)
+}
+
 // makeInternalStackCode builds a function for making an an internal error with a stack trace.
-func makeInternalStackCode(defaultCode Code) func(error) StackCode {
+// The returned function must be called directly by the exported constructor:
+// the stack position of 3 removes the returned function and the constructor
+// so that the stack starts at the caller of the constructor.
+// The skip argument of the returned function removes additional callers.
+func makeInternalStackCode(defaultCode Code) func(error, int) StackCode {
 	if !defaultCode.IsAncestor(InternalCode) {
 		panic(fmt.Errorf("code is not an internal code: %v", defaultCode))
 	}
-	return func(err error) StackCode {
+	return func(err error, skip int) StackCode {
 		if err == nil {
 			panic("makeInternalStackCode error is nil")
 		}
 		code := defaultCode
 		if errcode, ok := err.(ErrorCode); ok {
 			errCode := errcode.Code()
+			// keep the code of an internal error: InternalCode or one of its descendants
 			if errCode.IsAncestor(InternalCode) {
 				code = errCode
 			}
 		}
-		return NewStackCode(CodedError{GetCode: code, Err: err}, 3)
+		return NewStackCode(CodedError{GetCode: code, Err: err}, 3+skip)
 	}
 }
 
@@ -119,9 +259,24 @@ var unimplementedStackCode = makeInternalStackCode(UnimplementedCode)
 // This ensures the intention of sending an HTTP 50x.
 // This function also records a stack trace.
 func NewUnimplementedErr(err error) ErrorCode {
-	return unimplementedErr{unimplementedStackCode(err)}
+	return unimplementedErr{unimplementedStackCode(err, 0)}
+}
+
+// GetClientData returns InternalClientData so that the cause is not leaked to the client.
+func (e unimplementedErr) GetClientData() interface{} {
+	return internalClientData(e.Code())
+}
+
+// GetUserMsg returns the generic HTTP status text so that UserMsg does not fall back to the message of the cause.
+func (e unimplementedErr) GetUserMsg() string {
+	return internalClientData(e.Code()).Msg
 }
 
+var _ ErrorCode = (*unimplementedErr)(nil)     // assert implements interface
+var _ HasClientData = (*unimplementedErr)(nil) // assert implements interface
+var _ HasUserMsg = (*unimplementedErr)(nil)    // assert implements interface
+var _ Causer = (*unimplementedErr)(nil)        // assert implements interface
+
 // notFound gives the code NotFoundCode.
 type notFoundErr struct{ CodedError }
 
@@ -150,6 +305,34 @@ var _ ErrorCode = (*notAuthenticatedErr)(nil)     // assert implements interface
 var _ HasClientData = (*notAuthenticatedErr)(nil) // assert implements interface
 var _ Causer = (*notAuthenticatedErr)(nil)        // assert implements interface
 
+// tokenExpiredErr gives the code TokenExpiredCode.
+type tokenExpiredErr struct{ CodedError }
+
+// NewTokenExpiredErr creates a tokenExpiredErr from an err.
+// If the error is already an ErrorCode it will use that code.
+// Otherwise it will use TokenExpiredCode which gives HTTP 401.
+func NewTokenExpiredErr(err error) ErrorCode {
+	return tokenExpiredErr{NewCodedError(err, TokenExpiredCode)}
+}
+
+var _ ErrorCode = (*tokenExpiredErr)(nil)     // assert implements interface
+var _ HasClientData = (*tokenExpiredErr)(nil) // assert implements interface
+var _ Causer = (*tokenExpiredErr)(nil)        // assert implements interface
+
+// invalidCredentialsErr gives the code InvalidCredentialsCode.
+type invalidCredentialsErr struct{ CodedError }
+
+// NewInvalidCredentialsErr creates an invalidCredentialsErr from an err.
+// If the error is already an ErrorCode it will use that code.
+// Otherwise it will use InvalidCredentialsCode which gives HTTP 401.
+func NewInvalidCredentialsErr(err error) ErrorCode {
+	return invalidCredentialsErr{NewCodedError(err, InvalidCredentialsCode)}
+}
+
+var _ ErrorCode = (*invalidCredentialsErr)(nil)     // assert implements interface
+var _ HasClientData = (*invalidCredentialsErr)(nil) // assert implements interface
+var _ Causer = (*invalidCredentialsErr)(nil)        // assert implements interface
+
 // forbiddenErr gives the code ForbiddenCode.
 type forbiddenErr struct{ CodedError }
 
@@ -164,6 +347,157 @@ var _ ErrorCode = (*forbiddenErr)(nil)     // assert implements interface
 var _ HasClientData = (*forbiddenErr)(nil) // assert implements interface
 var _ Causer = (*forbiddenErr)(nil)        // assert implements interface
 
+// ScopeClientData is the client data of NewForbiddenErrScope.
+// Only the required scope is given, never the scopes the user has.
+// An empty RequiredScope is left out.
+type ScopeClientData struct {
+	RequiredScope string `json:"requiredScope,omitempty"`
+}
+
+// forbiddenScopeErr gives the code ForbiddenCode.
+// The required scope is sent to the client.
+type forbiddenScopeErr struct {
+	CodedError
+	requiredScope string
+}
+
+// NewForbiddenErrScope is the same as NewForbiddenErr
+// but also gives the permission or scope that was required in the client data as ScopeClientData.
+func NewForbiddenErrScope(err error, requiredScope string) ErrorCode {
+	return forbiddenScopeErr{CodedError: NewCodedError(err, ForbiddenCode), requiredScope: requiredScope}
+}
+
+// GetClientData returns ScopeClientData.
+func (e forbiddenScopeErr) GetClientData() interface{} {
+	return ScopeClientData{RequiredScope: e.requiredScope}
+}
+
+var _ ErrorCode = (*forbiddenScopeErr)(nil)     // assert implements interface
+var _ HasClientData = (*forbiddenScopeErr)(nil) // assert implements interface
+var _ Causer = (*forbiddenScopeErr)(nil)        // assert implements interface
+
+// paymentRequiredErr gives the code PaymentRequiredCode.
+// The Reason is sent to the client.
+type paymentRequiredErr struct {
+	Reason string `json:"reason"`
+}
+
+// NewPaymentRequiredErr creates a paymentRequiredErr which gives HTTP 402.
+// The reason (for example "trial_expired") is given in the client data.
+func NewPaymentRequiredErr(reason string) ErrorCode {
+	return paymentRequiredErr{Reason: reason}
+}
+
+func (e paymentRequiredErr) Error() string {
+	return "payment required: " + e.Reason
+}
+
+// Code returns PaymentRequiredCode
+func (e paymentRequiredErr) Code() Code {
+	return PaymentRequiredCode
+}
+
+var _ ErrorCode = (*paymentRequiredErr)(nil) // assert implements interface
+
+// degradedErr gives the code DegradedCode.
+// The disabled Feature is sent to the client.
+type degradedErr struct {
+	Feature string `json:"feature"`
+}
+
+// NewDegradedErr creates a degradedErr which gives HTTP 203.
+// The feature that is disabled (for example "recommendations") is given in the client data.
+func NewDegradedErr(feature string) ErrorCode {
+	return degradedErr{Feature: feature}
+}
+
+func (e degradedErr) Error() string {
+	return "degraded: " + e.Feature + " is unavailable"
+}
+
+// Code returns DegradedCode
+func (e degradedErr) Code() Code {
+	return DegradedCode
+}
+
+var _ ErrorCode = (*degradedErr)(nil) // assert implements interface
+
+// idempotencyConflictErr gives the code IdempotencyConflictCode.
+// The Key is sent to the client.
+type idempotencyConflictErr struct {
+	Key string `json:"key"`
+}
+
+// NewIdempotencyConflictErr creates an idempotencyConflictErr which gives HTTP 422.
+// The reused idempotency key is given in the client data.
+func NewIdempotencyConflictErr(key string) ErrorCode {
+	return idempotencyConflictErr{Key: key}
+}
+
+func (e idempotencyConflictErr) Error() string {
+	return "idempotency key reused with a different payload: " + e.Key
+}
+
+// Code returns IdempotencyConflictCode
+func (e idempotencyConflictErr) Code() Code {
+	return IdempotencyConflictCode
+}
+
+var _ ErrorCode = (*idempotencyConflictErr)(nil) // assert implements interface
+
+// timeoutErr gives the code TimeoutCode.
+type timeoutErr struct{ CodedError }
+
+// NewTimeoutErr creates a timeoutErr from an err.
+// If the given err is an ErrorCode that is a descendant of TimeoutCode,
+// its code will be used.
+// Otherwise it will use TimeoutCode which gives HTTP 504.
+func NewTimeoutErr(err error) ErrorCode {
+	if err == nil {
+		panic("NewTimeoutErr error is nil")
+	}
+	code := TimeoutCode
+	if errcode, ok := err.(ErrorCode); ok {
+		errCode := errcode.Code()
+		if errCode.IsAncestor(TimeoutCode) {
+			code = errCode
+		}
+	}
+	return timeoutErr{CodedError{GetCode: code, Err: err}}
+}
+
+var _ ErrorCode = (*timeoutErr)(nil)     // assert implements interface
+var _ HasClientData = (*timeoutErr)(nil) // assert implements interface
+var _ Causer = (*timeoutErr)(nil)        // assert implements interface
+
+// unavailableErr gives the code UnavailableCode.
+type unavailableErr struct{ CodedError }
+
+// NewUnavailableErr creates an unavailableErr from an err.
+// If the error is already an ErrorCode it will use that code.
+// Otherwise it will use UnavailableCode which gives HTTP 503.
+func NewUnavailableErr(err error) ErrorCode {
+	return unavailableErr{NewCodedError(err, UnavailableCode)}
+}
+
+var _ ErrorCode = (*unavailableErr)(nil)     // assert implements interface
+var _ HasClientData = (*unavailableErr)(nil) // assert implements interface
+var _ Causer = (*unavailableErr)(nil)        // assert implements interface
+
+// rateLimitErr gives the code RateLimitCode.
+type rateLimitErr struct{ CodedError }
+
+// NewRateLimitErr creates a rateLimitErr from an err.
+// If the error is already an ErrorCode it will use that code.
+// Otherwise it will use RateLimitCode which gives HTTP 429.
+func NewRateLimitErr(err error) ErrorCode {
+	return rateLimitErr{NewCodedError(err, RateLimitCode)}
+}
+
+var _ ErrorCode = (*rateLimitErr)(nil)     // assert implements interface
+var _ HasClientData = (*rateLimitErr)(nil) // assert implements interface
+var _ Causer = (*rateLimitErr)(nil)        // assert implements interface
+
 // CodedError is a convenience to attach a code to an error and already satisfy the ErrorCode interface.
 // If the error is a struct, that struct will get preseneted as data to the client.
 //
@@ -192,6 +526,32 @@ func NewCodedError(err error, code Code) CodedError {
 	return CodedError{GetCode: code, Err: err}
 }
 
+// NewCodedErrorf formats a message with fmt.Errorf and attaches the code to it.
+//
+// Unlike NewCodedError, the code is always the given code:
+// an ErrorCode in the args (for example wrapped with %w) does not replace it.
+func NewCodedErrorf(code Code, format string, args ...interface{}) CodedError {
+	return CodedError{GetCode: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Err gives an ErrorCode for the code.
+// The message is the Description of the code or its CodeStr if it has no description.
+func (code Code) Err() ErrorCode {
+	msg := code.Description()
+	if msg == "" {
+		msg = code.CodeStr().String()
+	}
+	return CodedError{GetCode: code, Err: errors.New(msg)}
+}
+
+// Errorf gives an ErrorCode for the code with a formatted message.
+// This is the same as NewCodedErrorf:
+//
+//	return errcode.NotFoundCode.Errorf("user %d", id)
+func (code Code) Errorf(format string, args ...interface{}) ErrorCode {
+	return NewCodedErrorf(code, format, args...)
+}
+
 var _ ErrorCode = (*CodedError)(nil)     // assert implements interface
 var _ HasClientData = (*CodedError)(nil) // assert implements interface
 var _ Causer = (*CodedError)(nil)        // assert implements interface
@@ -205,11 +565,21 @@ func (e CodedError) Cause() error {
 	return e.Err
 }
 
+// Unwrap gives Err for errors.Is and errors.As.
+func (e CodedError) Unwrap() error {
+	return e.Err
+}
+
 // Code returns the GetCode field
 func (e CodedError) Code() Code {
 	return e.GetCode
 }
 
+// Is matches a sentinel error with the same code, such as ErrNotFound, for errors.Is.
+func (e CodedError) Is(target error) bool {
+	return isSentinel(e.GetCode, target)
+}
+
 // GetClientData returns the underlying Err field.
 func (e CodedError) GetClientData() interface{} {
 	if errCode, ok := e.Err.(ErrorCode); ok {
@@ -0,0 +1,78 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"github.com/pingcap/errors"
+)
+
+// Conflict describes the resource that already exists for an AlreadyExistsCode error.
+// Empty fields are left out of the client data.
+type Conflict struct {
+	Resource string `json:"resource,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+// HasConflict is an interface to retrieve the Conflict of an error.
+// Generally the conflict should be retrieved with the ConflictDetail function.
+type HasConflict interface {
+	GetConflict() Conflict
+}
+
+// ConflictDetail finds the first HasConflict in the Causer chain of the error.
+// The second return value is false if there is no conflict.
+func ConflictDetail(err error) (Conflict, bool) {
+	found := errors.Find(err, func(err error) bool {
+		_, ok := err.(HasConflict)
+		return ok
+	})
+	if found == nil {
+		return Conflict{}, false
+	}
+	return found.(HasConflict).GetConflict(), true
+}
+
+// alreadyExistsErr gives the code AlreadyExistsCode.
+// The Conflict is sent to the client.
+type alreadyExistsErr struct {
+	CodedError
+	conflict Conflict
+}
+
+// NewAlreadyExistsErr creates an alreadyExistsErr from an err.
+// The resource (for example "user") and key (for example the user name) that conflicted
+// are given in the client data. Either may be empty.
+// If the error is already an ErrorCode it will use that code.
+// Otherwise it will use AlreadyExistsCode which gives HTTP 409.
+func NewAlreadyExistsErr(err error, resource, key string) ErrorCode {
+	return alreadyExistsErr{
+		CodedError: NewCodedError(err, AlreadyExistsCode),
+		conflict:   Conflict{Resource: resource, Key: key},
+	}
+}
+
+// GetConflict satisfies the HasConflict interface
+func (e alreadyExistsErr) GetConflict() Conflict {
+	return e.conflict
+}
+
+// GetClientData returns the Conflict.
+func (e alreadyExistsErr) GetClientData() interface{} {
+	return e.conflict
+}
+
+var _ ErrorCode = (*alreadyExistsErr)(nil)     // assert implements interface
+var _ HasClientData = (*alreadyExistsErr)(nil) // assert implements interface
+var _ HasConflict = (*alreadyExistsErr)(nil)   // assert implements interface
+var _ Causer = (*alreadyExistsErr)(nil)        // assert implements interface
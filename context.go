@@ -0,0 +1,33 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import "context"
+
+// contextKey is the key for an ErrorCode in a context.Context
+type contextKey struct{}
+
+// NewContext gives a context carrying an ErrorCode.
+// Middleware can use this to record the intended code for a request
+// so that a later handler or the final responder can retrieve it with FromContext.
+func NewContext(ctx context.Context, errCode ErrorCode) context.Context {
+	return context.WithValue(ctx, contextKey{}, errCode)
+}
+
+// FromContext retrieves an ErrorCode stored with NewContext.
+// The second return value is false if the context has no ErrorCode.
+func FromContext(ctx context.Context) (ErrorCode, bool) {
+	errCode, ok := ctx.Value(contextKey{}).(ErrorCode)
+	return errCode, ok
+}
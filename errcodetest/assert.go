@@ -0,0 +1,53 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcodetest
+
+import (
+	"testing"
+
+	"github.com/pingcap/errcode"
+)
+
+// AssertCode checks that the ErrorCode found by CodeChain has exactly the code want.
+// The test fails if err is nil or contains no ErrorCode.
+// Use AssertCodeAncestor to also accept descendants of want.
+func AssertCode(t testing.TB, err error, want errcode.Code) {
+	t.Helper()
+	if got, ok := chainCode(t, err, want); ok && !got.Equal(want) {
+		t.Errorf("expected code %v but got %v for error: %v", want.CodeStr(), got.CodeStr(), err)
+	}
+}
+
+// AssertCodeAncestor checks that the code of the ErrorCode found by CodeChain is want or a descendant of want.
+// The test fails if err is nil or contains no ErrorCode.
+func AssertCodeAncestor(t testing.TB, err error, want errcode.Code) {
+	t.Helper()
+	if got, ok := chainCode(t, err, want); ok && !got.IsAncestor(want) {
+		t.Errorf("expected code %v or a descendant but got %v for error: %v", want.CodeStr(), got.CodeStr(), err)
+	}
+}
+
+func chainCode(t testing.TB, err error, want errcode.Code) (errcode.Code, bool) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("expected code %v but got a nil error", want.CodeStr())
+		return errcode.Code{}, false
+	}
+	errCode := errcode.CodeChain(err)
+	if errCode == nil {
+		t.Errorf("expected code %v but got an error with no code: %v", want.CodeStr(), err)
+		return errcode.Code{}, false
+	}
+	return errCode.Code(), true
+}
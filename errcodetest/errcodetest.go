@@ -0,0 +1,98 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package errcodetest provides helpers for testing code that uses error codes.
+// It is kept separate from the errcode package so that helpers can depend on the testing package.
+package errcodetest
+
+import (
+	"time"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errors"
+)
+
+// TestErr is an ErrorCode where every field is controlled by the test.
+// This gives stable output for golden file tests of error handling.
+// It should be constructed with NewTestErr.
+type TestErr struct {
+	GetCode   errcode.Code
+	Msg       string
+	Stack     errors.StackTrace
+	Timestamp time.Time
+	Context   map[string]interface{}
+}
+
+// TestOpt sets an optional field of a TestErr.
+type TestOpt func(*TestErr)
+
+// WithStack gives a TestErr a fixed stack trace.
+func WithStack(stack errors.StackTrace) TestOpt {
+	return func(e *TestErr) { e.Stack = stack }
+}
+
+// WithTimestamp gives a TestErr a fixed timestamp.
+func WithTimestamp(timestamp time.Time) TestOpt {
+	return func(e *TestErr) { e.Timestamp = timestamp }
+}
+
+// WithContext adds a context value to a TestErr.
+func WithContext(key string, value interface{}) TestOpt {
+	return func(e *TestErr) {
+		if e.Context == nil {
+			e.Context = make(map[string]interface{})
+		}
+		e.Context[key] = value
+	}
+}
+
+// NewTestErr constructs a TestErr.
+// Without options there is no stack trace, timestamp, or context.
+func NewTestErr(code errcode.Code, msg string, opts ...TestOpt) TestErr {
+	err := TestErr{GetCode: code, Msg: msg}
+	for _, opt := range opts {
+		opt(&err)
+	}
+	return err
+}
+
+var _ errcode.ErrorCode = (*TestErr)(nil)     // assert implements interface
+var _ errcode.HasClientData = (*TestErr)(nil) // assert implements interface
+var _ errors.StackTracer = (*TestErr)(nil)    // assert implements interface
+
+func (e TestErr) Error() string {
+	return e.Msg
+}
+
+// Code returns the GetCode field
+func (e TestErr) Code() errcode.Code {
+	return e.GetCode
+}
+
+// StackTrace fulfills the StackTracer interface
+func (e TestErr) StackTrace() errors.StackTrace {
+	return e.Stack
+}
+
+// GetClientData gives the timestamp and context.
+// Fields that were not set are left out.
+func (e TestErr) GetClientData() interface{} {
+	data := make(map[string]interface{})
+	if !e.Timestamp.IsZero() {
+		data["timestamp"] = e.Timestamp
+	}
+	if len(e.Context) > 0 {
+		data["context"] = e.Context
+	}
+	return data
+}
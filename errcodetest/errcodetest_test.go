@@ -0,0 +1,147 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcodetest_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errcode/errcodetest"
+	"github.com/pingcap/errors"
+)
+
+func TestNewTestErr(t *testing.T) {
+	newErr := func() errcodetest.TestErr {
+		return errcodetest.NewTestErr(errcode.InternalCode, "fixed",
+			errcodetest.WithStack(errors.StackTrace{1, 2}),
+			errcodetest.WithTimestamp(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)),
+			errcodetest.WithContext("user", 7),
+			errcodetest.WithContext("request", "abc"),
+		)
+	}
+	expected := `{"code":"internal","category":"internal","msg":"fixed","data":{"context":{"request":"abc","user":7},"timestamp":"2018-01-02T03:04:05Z"},"stack":[1,2]}`
+	for i := 0; i < 2; i++ {
+		AssertJSON(t, newErr(), expected)
+	}
+
+	AssertJSON(t, errcodetest.NewTestErr(errcode.NotFoundCode, "minimal"), `{"code":"missing","category":"notfound","msg":"minimal","data":{}}`)
+}
+
+func AssertJSON(t *testing.T, errCode errcode.ErrorCode, expected string) {
+	t.Helper()
+	got, err := json.Marshal(errcode.NewJSONFormat(errCode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expected {
+		t.Errorf("expected JSON %v\ngot %v", expected, string(got))
+	}
+}
+
+// fakeTB records failures instead of failing the test
+type fakeTB struct {
+	testing.TB
+	failures []string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Errorf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func (tb *fakeTB) Fatalf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func TestAssertGoldenJSON(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "missing.json")
+	errCode := errcodetest.NewTestErr(errcode.NotFoundCode, "golden")
+
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertGoldenJSON(tb, errCode, golden) })
+
+	SetUpdate(t, true)
+	AssertFailures(t, 0, func(tb *fakeTB) { errcodetest.AssertGoldenJSON(tb, errCode, golden) })
+	SetUpdate(t, false)
+	written, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "code": "missing",
+  "category": "notfound",
+  "msg": "golden",
+  "data": {}
+}
+`
+	if string(written) != expected {
+		t.Errorf("expected golden file %v\ngot %v", expected, string(written))
+	}
+
+	errcodetest.AssertGoldenJSON(t, errCode, golden)
+	changed := errcodetest.NewTestErr(errcode.NotFoundCode, "changed")
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertGoldenJSON(tb, changed, golden) })
+}
+
+func SetUpdate(t *testing.T, update bool) {
+	t.Helper()
+	if err := flag.Set("errcodetest.update", fmt.Sprint(update)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func AssertFailures(t *testing.T, expected int, fn func(*fakeTB)) {
+	t.Helper()
+	tb := &fakeTB{}
+	fn(tb)
+	if len(tb.failures) != expected {
+		t.Errorf("expected %v failures but got %v", expected, tb.failures)
+	}
+}
+
+func TestAssertHierarchyConsistent(t *testing.T) {
+	errcodetest.AssertHierarchyConsistent(t, errcode.InternalCode)
+	errcodetest.AssertHierarchyConsistent(t, errcode.IdempotencyConflictCode)
+
+	handMade := errcode.Code{Parent: &errcode.StateCode}
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertHierarchyConsistent(tb, handMade) })
+	unregistered := errcode.Code{}
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertHierarchyConsistent(tb, unregistered) })
+}
+
+func TestAssertCode(t *testing.T) {
+	wrapped := errors.Wrap(errcode.NewNotFoundErr(errors.New("no user")), "lookup")
+	errcodetest.AssertCode(t, wrapped, errcode.NotFoundCode)
+	errcodetest.AssertCodeAncestor(t, wrapped, errcode.NotFoundCode)
+
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertCode(tb, wrapped, errcode.InternalCode) })
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertCode(tb, nil, errcode.NotFoundCode) })
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertCode(tb, errors.New("plain"), errcode.NotFoundCode) })
+
+	conflict := errcode.IdempotencyConflictCode.Err()
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertCode(tb, conflict, errcode.StateCode) })
+	errcodetest.AssertCodeAncestor(t, conflict, errcode.StateCode)
+	AssertFailures(t, 1, func(tb *fakeTB) { errcodetest.AssertCodeAncestor(tb, conflict, errcode.NotFoundCode) })
+}
+
+func TestAssertGoldenJSONBuiltin(t *testing.T) {
+	errcodetest.AssertGoldenJSON(t, errcode.NewPaymentRequiredErr("trial_expired"), filepath.Join("testdata", "payment_required.json"))
+	errcodetest.AssertGoldenJSON(t, errcode.NewNotFoundErr(errors.New("no user")), filepath.Join("testdata", "not_found.json"))
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcodetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pingcap/errcode"
+)
+
+// update regenerates golden files rather than comparing against them.
+// Run the tests with -errcodetest.update to use it.
+var update = flag.Bool("errcodetest.update", false, "update errcodetest golden files")
+
+// AssertGoldenJSON compares the JSONFormat of an ErrorCode against the contents of a golden file.
+// The JSON is indented and deterministic: struct fields keep their order and map keys are sorted,
+// so diffs of golden files are clean.
+// When the tests are run with -errcodetest.update the golden file is written instead.
+// Use TestErr to produce an error with stable output.
+func AssertGoldenJSON(t testing.TB, errCode errcode.ErrorCode, goldenPath string) {
+	t.Helper()
+	got, err := json.MarshalIndent(errcode.NewJSONFormat(errCode), "", "  ")
+	if err != nil {
+		t.Fatalf("marshal %v: %v", goldenPath, err)
+		return
+	}
+	got = append(got, '\n')
+
+	if *update {
+		if err := ioutil.WriteFile(goldenPath, got, 0644); err != nil {
+			t.Fatalf("update golden file %v: %v", goldenPath, err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("read golden file %v: %v", goldenPath, err)
+		return
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("JSON does not match golden file %v\nexpected %s\ngot %s", goldenPath, expected, got)
+	}
+}
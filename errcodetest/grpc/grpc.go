@@ -0,0 +1,42 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpc provides test helpers for GRPC statuses.
+// It is kept separate from the grpc package so that the testing package is only imported by tests.
+package grpc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/status"
+)
+
+// RequireDetail looks for a detail in the status with the same message type as want.
+// The test fails immediately if no such detail is present or if it is not equal to want.
+func RequireDetail(t testing.TB, st *status.Status, want proto.Message) {
+	t.Helper()
+	wantType := reflect.TypeOf(want)
+	for _, detail := range st.Details() {
+		got, ok := detail.(proto.Message)
+		if !ok || reflect.TypeOf(got) != wantType {
+			continue
+		}
+		if !proto.Equal(got, want) {
+			t.Fatalf("status detail %v expected %v but got %v", wantType, want, got)
+		}
+		return
+	}
+	t.Fatalf("status detail %v not found in %v", wantType, st.Details())
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/errcode/errcodetest/grpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeTB records failures instead of failing the test
+type fakeTB struct {
+	testing.TB
+	failures []string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Fatalf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func TestRequireDetail(t *testing.T) {
+	help := &errdetails.Help{Links: []*errdetails.Help_Link{{Description: "docs", Url: "https://example.com"}}}
+	st, err := status.New(codes.NotFound, "missing").WithDetails(help)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grpc.RequireDetail(t, st, help)
+
+	AssertFailures(t, 0, func(tb *fakeTB) { grpc.RequireDetail(tb, st, help) })
+	AssertFailures(t, 1, func(tb *fakeTB) { grpc.RequireDetail(tb, st, &errdetails.RetryInfo{}) })
+	AssertFailures(t, 1, func(tb *fakeTB) { grpc.RequireDetail(tb, st, &errdetails.Help{}) })
+	AssertFailures(t, 1, func(tb *fakeTB) { grpc.RequireDetail(tb, status.New(codes.NotFound, "missing"), help) })
+}
+
+func AssertFailures(t *testing.T, expected int, fn func(*fakeTB)) {
+	t.Helper()
+	tb := &fakeTB{}
+	fn(tb)
+	if len(tb.failures) != expected {
+		t.Errorf("expected %v failures but got %v", expected, tb.failures)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcodetest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/errcode"
+)
+
+// AssertHierarchyConsistent checks that the Parent chain of a code matches the dot separated paths of its CodeStr.
+// Each path must be non-empty and each ancestor must be registered under the CodeStr of its paths.
+// A code created with NewCode or Child is always consistent:
+// this catches codes constructed by hand rather than with Child.
+func AssertHierarchyConsistent(t testing.TB, code errcode.Code) {
+	t.Helper()
+	paths := strings.Split(code.CodeStr().String(), ".")
+	i := len(paths)
+	for ancestor := &code; ancestor != nil; ancestor = ancestor.Parent {
+		expected := errcode.CodeStr(strings.Join(paths[:i], "."))
+		if paths[i-1] == "" {
+			t.Errorf("code %v has an empty path for ancestor %v", code.CodeStr(), expected)
+			return
+		}
+		if registered, ok := errcode.LookupCode(expected); !ok || !registered.Equal(*ancestor) {
+			t.Errorf("code %v has an ancestor %v that is not registered", code.CodeStr(), expected)
+			return
+		}
+		i--
+	}
+}
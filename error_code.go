@@ -43,7 +43,10 @@
 package errcode
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/pingcap/errors"
@@ -81,18 +84,24 @@ func (code Code) CodeStr() CodeStr {
 
 // NewCode creates a new top-level code.
 // A top-level code must not contain any dot separators: that will panic
+// The code must be non-empty and contain only letters, digits, '_', or '-': otherwise it will panic.
 // Most codes should be created from hierachry with the Child method.
+// A code that was already created (see RegisteredCodes) panics.
 func NewCode(codeRep CodeStr) Code {
 	code := Code{codeStr: codeRep}
 	if err := code.checkCodePath(); err != nil {
 		panic(err)
 	}
+	if err := code.register(); err != nil {
+		panic(err)
+	}
 	return code
 }
 
 // Child creates a new code from a parent.
-// For documentation purposes, a childStr may include the parent codes with dot-separation.
+// The childStr must be the full CodeStr of the parent followed by a dot and the child path.
 // An incorrect parent reference in the string panics.
+// A code that was already created (see RegisteredCodes) panics.
 func (code Code) Child(childStr CodeStr) Code {
 	child := Code{codeStr: childStr, Parent: &code}
 	if err := child.checkCodePath(); err != nil {
@@ -101,6 +110,9 @@ func (code Code) Child(childStr CodeStr) Code {
 	// Don't store parent paths, those are re-constructed in CodeStr()
 	paths := strings.Split(child.codeStr.String(), ".")
 	child.codeStr = CodeStr(paths[len(paths)-1])
+	if err := child.register(); err != nil {
+		panic(err)
+	}
 	return child
 }
 
@@ -115,9 +127,64 @@ func (code Code) findAncestor(test func(Code) bool) *Code {
 	return (*code.Parent).findAncestor(test)
 }
 
+// Equal tells whether two codes are the same code by comparing their CodeStr.
+// Code values should not be compared with ==:
+// the Parent pointers of two values for the same code may differ.
+func (code Code) Equal(other Code) bool {
+	return code.CodeStr() == other.CodeStr()
+}
+
 // IsAncestor looks for the given code in its ancestors.
+// A code is its own ancestor, the same as MetaDataFromAncestors starts at the current code:
+// code.IsAncestor(code) is true for any code, including a root code without a Parent.
+// To exclude the code itself, also check !code.Equal(ancestorCode).
 func (code Code) IsAncestor(ancestorCode Code) bool {
-	return nil != code.findAncestor(func(an Code) bool { return an == ancestorCode })
+	return nil != code.findAncestor(ancestorCode.Equal)
+}
+
+// IsDescendant looks for this code in the ancestors of the given code.
+// It is the mirror of IsAncestor: code.IsDescendant(other) == other.IsAncestor(code).
+// Like IsAncestor, a code is its own descendant.
+func (code Code) IsDescendant(descendantCode Code) bool {
+	return descendantCode.IsAncestor(code)
+}
+
+// InFamily tells whether the code is the given ancestor code or one of its descendants.
+// This is the same as IsAncestor but reads better for checking a family of codes:
+// NotAuthenticatedCode.InFamily(AuthCode) is true.
+func (code Code) InFamily(ancestorCode Code) bool {
+	return code.IsAncestor(ancestorCode)
+}
+
+// InFamily is a convenience for checking whether the Code of an ErrorCode is in the family of the ancestor code.
+// A nil ErrorCode is not in any family.
+//
+//	if errcode.InFamily(errCode, errcode.AuthCode) { ... }
+func InFamily(errCode ErrorCode, ancestorCode Code) bool {
+	if errCode == nil {
+		return false
+	}
+	return errCode.Code().InFamily(ancestorCode)
+}
+
+// Ancestors gives the chain of codes from the code itself up to and including its root code.
+// This is the order in which MetaDataFromAncestors looks for meta data.
+// AlreadyExistsCode.Ancestors() is [AlreadyExistsCode, StateCode].
+func (code Code) Ancestors() []Code {
+	ancestors := []Code{code}
+	for parent := code.Parent; parent != nil; parent = parent.Parent {
+		ancestors = append(ancestors, *parent)
+	}
+	return ancestors
+}
+
+// Root gives the top-most ancestor of the code, the one without a Parent.
+// A root code is its own Root.
+func (code Code) Root() Code {
+	for code.Parent != nil {
+		code = *code.Parent
+	}
+	return code
 }
 
 // ErrorCode is the interface that ties an error and RegisteredCode together.
@@ -173,9 +240,26 @@ type HasClientData interface {
 	GetClientData() interface{}
 }
 
+// HasClientDataMarshal is an optional fast path for serializing client data to JSON.
+// NewJSONFormat uses the result of MarshalClientData as the Data field (a json.RawMessage)
+// rather than reflecting over the result of GetClientData.
+// The JSON must be the same as the JSON of ClientData.
+// If MarshalClientData returns an error, ClientData is used instead.
+// It is also found on an error wrapped with NewStackCode, WithOperation, and other wrappers
+// that do not change the client data.
+type HasClientDataMarshal interface {
+	MarshalClientData() ([]byte, error)
+}
+
 // ClientData retrieves data from a structure that implements HasClientData
 // If HasClientData is not defined it will use the given ErrorCode object.
 // Normally this function is used rather than GetClientData.
+//
+// ClientData itself does not recurse: the result of GetClientData is returned as is.
+// Wrappers provided by this package (for example CodedError, StackCode, and OpErrCode)
+// implement GetClientData by calling ClientData on the ErrorCode they wrap.
+// So when the wrapped error is itself an ErrorCode, its client data is returned rather than the error value.
+// The HasOperation interface of the returned data is still checked by OperationClientData.
 func ClientData(errCode ErrorCode) interface{} {
 	var data interface{} = errCode
 	if hasData, ok := errCode.(HasClientData); ok {
@@ -184,21 +268,56 @@ func ClientData(errCode ErrorCode) interface{} {
 	return data
 }
 
+// FullClientData gives a self-describing form of the client data that includes the code and message.
+// The fields of client data that serializes to a JSON object are kept alongside "code" and "msg",
+// which take precedence over fields of the same name.
+// Other client data (including nil) is kept in a "data" field.
+// The msg is given by UserMsg.
+// A redacted code (see SetRedacted) gives the fields of RedactedClientData.
+//
+// ClientData is unchanged so that existing consumers of it are not affected.
+func FullClientData(errCode ErrorCode) map[string]interface{} {
+	var data interface{}
+	if errCode.Code().IsRedacted() {
+		data = redactedClientData(errCode.Code())
+	} else {
+		data = clientDataJSON(errCode, ClientData(errCode))
+	}
+	var full map[string]interface{}
+	if encoded, err := json.Marshal(data); err == nil {
+		// a JSON null leaves full as nil
+		_ = json.Unmarshal(encoded, &full)
+	}
+	if full == nil {
+		full = map[string]interface{}{"data": data}
+	}
+	full["code"] = errCode.Code().CodeStr()
+	full["msg"] = UserMsg(errCode)
+	return full
+}
+
 // JSONFormat is an opinion on how to serialize an ErrorCode to JSON.
 // * Code is the error code string (CodeStr)
-// * Msg is the string from Error() and should be friendly to end users.
+// * Category is the coarse Category of the code. It is missing if the code has no category.
+// * Msg is the string from UserMsg (which defaults to Error()) and should be friendly to end users.
 // * Data is the ad-hoc data filled in by GetClientData and should be consumable by clients.
+//   If the data implements json.Marshaler, its MarshalJSON is used.
+//   If the code is redacted (see SetRedacted), Data is a RedactedClientData instead.
+//   If the ErrorCode implements HasClientDataMarshal, Data is the json.RawMessage it gives.
 // * Operation is the high-level operation that was happening at the time of the error.
-// The Operation field may be missing, and the Data field may be empty.
+// * Count is the number of times the error occurred if it was collapsed by MultiErrCode.Dedup.
+// The Operation and Count fields may be missing, and the Data field may be empty.
 //
 // The rest of the fields may be populated sparsely depending on the application:
 // * Stack is a stack trace. This is only given for internal errors.
 // * Others gives other errors that occurred (perhaps due to parallel requests).
 type JSONFormat struct {
 	Code      CodeStr           `json:"code"`
+	Category  Category          `json:"category,omitempty"`
 	Msg       string            `json:"msg"`
 	Data      interface{}       `json:"data"`
 	Operation string            `json:"operation,omitempty"`
+	Count     int               `json:"count,omitempty"`
 	Stack     errors.StackTrace `json:"stack,omitempty"`
 	Others    []JSONFormat      `json:"others,omitempty"`
 }
@@ -228,6 +347,11 @@ func NewJSONFormat(errCode ErrorCode) JSONFormat {
 	}
 
 	op, data := OperationClientData(errCode)
+	if errCode.Code().IsRedacted() {
+		data = redactedClientData(errCode.Code())
+	} else {
+		data = clientDataJSON(errCode, data)
+	}
 
 	var stack errors.StackTrace
 	if errCode.Code().IsAncestor(InternalCode) {
@@ -236,30 +360,107 @@ func NewJSONFormat(errCode ErrorCode) JSONFormat {
 
 	return JSONFormat{
 		Data:      data,
-		Msg:       errCode.Error(),
+		Msg:       UserMsg(errCode),
 		Code:      errCode.Code().CodeStr(),
+		Category:  errCode.Code().Category(),
 		Operation: op,
+		Count:     DuplicateCount(errCode),
 		Stack:     stack,
 		Others:    others,
 	}
 }
 
+// MinimalJSON gives a small JSON representation of an ErrorCode with just the code and HTTP status (see HTTPStatus):
+// {"code":"internal","status":500}
+// It does not use reflection or client data, so it is suitable for frequent requests such as health checks.
+// A CodeStr only contains characters that do not need escaping in JSON.
+func MinimalJSON(errCode ErrorCode) []byte {
+	code := errCode.Code()
+	codeStr := code.CodeStr()
+	buf := make([]byte, 0, len(codeStr)+len(`{"code":"","status":000}`))
+	buf = append(buf, `{"code":"`...)
+	buf = append(buf, codeStr...)
+	buf = append(buf, `","status":`...)
+	buf = strconv.AppendInt(buf, int64(code.HTTPStatus()), 10)
+	buf = append(buf, '}')
+	return buf
+}
+
+// clientDataKeeper is implemented by wrappers whose client data is the client data of the error they wrap,
+// such as StackCode and OpErrCode.
+type clientDataKeeper interface {
+	keepsClientData()
+}
+
+// clientDataJSON gives the client data to serialize to JSON.
+// The result of MarshalClientData is used directly for a HasClientDataMarshal.
+// The HasClientDataMarshal is looked up along the Causer chain only through a clientDataKeeper,
+// so that a wrapper that changes the client data is respected.
+func clientDataJSON(errCode ErrorCode, data interface{}) interface{} {
+	for err := error(errCode); err != nil; err = unwrap(err) {
+		if marshal, ok := err.(HasClientDataMarshal); ok {
+			if raw, marshalErr := marshal.MarshalClientData(); marshalErr == nil {
+				return json.RawMessage(raw)
+			}
+			break
+		}
+		if _, ok := err.(clientDataKeeper); !ok {
+			break
+		}
+	}
+	return jsonMarshalerData(data)
+}
+
+// jsonMarshalerData ensures that client data which defines its own JSON representation uses it.
+// encoding/json only finds a MarshalJSON defined on a pointer receiver if the value is addressable,
+// so such a value is copied into a pointer.
+func jsonMarshalerData(data interface{}) interface{} {
+	if data == nil {
+		return nil
+	}
+	if _, ok := data.(json.Marshaler); ok {
+		return data
+	}
+	ptr := reflect.New(reflect.TypeOf(data))
+	if _, ok := ptr.Interface().(json.Marshaler); ok {
+		ptr.Elem().Set(reflect.ValueOf(data))
+		return ptr.Interface()
+	}
+	return data
+}
+
 // checkCodePath checks that the given code string either
-// contains no dots or extends the parent code string
+// contains no dots or extends the full parent code string with one more path.
+// Each path must be non-empty and contain only letters, digits, '_', or '-'.
 func (code Code) checkCodePath() error {
 	paths := strings.Split(code.codeStr.String(), ".")
-	if len(paths) == 1 {
-		return nil
+	for _, path := range paths {
+		if err := checkCodePathChars(path); err != nil {
+			return fmt.Errorf("invalid code %#v: %v", code.codeStr, err)
+		}
 	}
 	if code.Parent == nil {
 		if len(paths) > 1 {
 			return fmt.Errorf("expected no parent paths: %#v", code.codeStr)
 		}
-	} else {
-		parent := *code.Parent
-		parentPath := paths[len(paths)-2]
-		if parentPath != parent.codeStr.String() {
-			return fmt.Errorf("got %#v but expected a path to parent %#v for %#v", parentPath, parent.codeStr, code.codeStr)
+		return nil
+	}
+	parentStr := code.Parent.CodeStr()
+	expected := parentStr + "." + CodeStr(paths[len(paths)-1])
+	if code.codeStr != expected {
+		return fmt.Errorf("got %#v but expected a path to parent %#v: %#v", code.codeStr, parentStr, expected)
+	}
+	return nil
+}
+
+func checkCodePathChars(path string) error {
+	if path == "" {
+		return fmt.Errorf("empty path")
+	}
+	for _, r := range path {
+		valid := ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '_' || r == '-'
+		if !valid {
+			return fmt.Errorf("invalid character %q", r)
 		}
 	}
 	return nil
@@ -14,13 +14,24 @@
 package errcode_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/pingcap/errcode"
+	errcodehttp "github.com/pingcap/errcode/http"
 	"github.com/pingcap/errors"
+	"golang.org/x/text/language"
 )
 
 // Test setting the HTTP code
@@ -30,15 +41,15 @@ func (e HTTPError) Error() string { return "error" }
 
 const httpCodeStr = "input.http"
 
-var codeHTTP900 = errcode.InvalidInputCode.Child(httpCodeStr).SetHTTP(900)
+var codeHTTP418 = errcode.InvalidInputCode.Child(httpCodeStr).SetHTTP(418)
 
 func (e HTTPError) Code() errcode.Code {
-	return codeHTTP900
+	return codeHTTP418
 }
 
 func TestHttpErrorCode(t *testing.T) {
 	http := HTTPError{}
-	AssertHTTPCode(t, http, 900)
+	AssertHTTPCode(t, http, 418)
 	ErrorEquals(t, http, "error")
 	ClientDataEquals(t, http, http, httpCodeStr)
 }
@@ -64,22 +75,17 @@ func TestMinimalErrorCode(t *testing.T) {
 	OpEquals(t, minimal, "")
 }
 
-// We don't prevent duplicate codes
-var childPathOnlyCode errcode.Code = errcode.InvalidInputCode.Child("testcode")
-
-type ChildOnlyError struct{}
-
-func (e ChildOnlyError) Error() string { return "error" }
-
-var _ errcode.ErrorCode = (*ChildOnlyError)(nil) // assert implements interface
-
-func (e ChildOnlyError) Code() errcode.Code { return childPathOnlyCode }
-
-func TestChildOnlyErrorCode(t *testing.T) {
-	coe := ChildOnlyError{}
-	AssertCodes(t, coe)
-	ErrorEquals(t, coe, "error")
-	ClientDataEquals(t, coe, coe)
+// The child must give the full path of the parent
+func TestCodePathValidation(t *testing.T) {
+	AssertPanics(t, "Child without the parent path", func() { errcode.InvalidInputCode.Child("childonly") })
+	AssertPanics(t, "Child with the wrong parent path", func() { errcode.InvalidInputCode.Child("state.childonly") })
+	AssertPanics(t, "Child with a partial parent path", func() { registeredCode.Child("testcode.childonly") })
+	AssertPanics(t, "Child with an extra path", func() { errcode.InvalidInputCode.Child("input.child.only") })
+	AssertPanics(t, "Child with an empty path", func() { errcode.InvalidInputCode.Child("input.") })
+	AssertPanics(t, "NewCode with a parent path", func() { errcode.NewCode("top.level") })
+	AssertPanics(t, "NewCode empty", func() { errcode.NewCode("") })
+	AssertPanics(t, "NewCode with a space", func() { errcode.NewCode("top level") })
+	AssertPanics(t, "NewCode with a slash", func() { errcode.NewCode("top/level") })
 }
 
 // Test a top-level error
@@ -111,14 +117,14 @@ var _ errcode.ErrorCode = (*DeepError)(nil) // assert implements interface
 
 const deepCodeStr errcode.CodeStr = "input.testcode.very.very.deep"
 
-var intermediateCode = registeredCode.Child("input.testcode.very").SetHTTP(800)
+var intermediateCode = registeredCode.Child("input.testcode.very").SetHTTP(451)
 var deepCode errcode.Code = intermediateCode.Child("input.testcode.very.very").Child(deepCodeStr)
 
 func (e DeepError) Code() errcode.Code { return deepCode }
 
 func TestDeepErrorCode(t *testing.T) {
 	deep := DeepError{}
-	AssertHTTPCode(t, deep, 800)
+	AssertHTTPCode(t, deep, 451)
 	AssertCode(t, deep, deepCodeStr)
 	ErrorEquals(t, deep, "error")
 	ClientDataEquals(t, deep, deep, deepCodeStr)
@@ -201,13 +207,13 @@ func TestNewInvalidInputErr(t *testing.T) {
 	AssertCode(t, err, internalCodeStr)
 	AssertHTTPCode(t, err, 500)
 	ErrorEquals(t, err, "error")
-	ClientDataEquals(t, err, MinimalError{}, internalCodeStr)
+	ClientDataEquals(t, err, internalClientData, internalCodeStr)
 
 	wrappedInternalErr := errcode.NewInternalErr(internalErr)
 	AssertCode(t, err, internalCodeStr)
 	AssertHTTPCode(t, err, 500)
 	ErrorEquals(t, err, "error")
-	ClientDataEquals(t, wrappedInternalErr, MinimalError{}, internalCodeStr)
+	ClientDataEquals(t, wrappedInternalErr, internalClientData, internalCodeStr)
 	// It should use the original stack trace, not the wrapped
 	AssertStackEquals(t, wrappedInternalErr, errcode.StackTrace(internalErr))
 
@@ -218,6 +224,50 @@ func TestNewInvalidInputErr(t *testing.T) {
 	ClientDataEquals(t, err, InternalChild{}, internalChildCodeStr)
 }
 
+var internalClientData = errcode.InternalClientData{Code: "internal", Msg: "Internal Server Error"}
+
+func TestInternalErrHidesCause(t *testing.T) {
+	secret := "password=hunter2 at db-3.internal:5432"
+	for _, err := range []errcode.ErrorCode{
+		errcode.NewInternalErr(errors.New(secret)),
+		errcode.NewInternalErr(errcode.NewInvalidInputErr(errors.New(secret))),
+		errcode.NewUnimplementedErr(errors.New(secret)),
+	} {
+		data, jsonErr := json.Marshal(errcode.ClientData(err))
+		if jsonErr != nil {
+			t.Fatal(jsonErr)
+		}
+		rec := httptest.NewRecorder()
+		if writeErr := errcodehttp.WriteError(rec, err); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		problem := httptest.NewRecorder()
+		if writeErr := errcodehttp.WriteProblemJSON(problem, err); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		for name, sent := range map[string]string{
+			"client data":    string(data),
+			"WriteError":     rec.Body.String(),
+			"problem+json":   problem.Body.String(),
+			"JSONFormat msg": errcode.NewJSONFormat(err).Msg,
+		} {
+			for _, part := range []string{"hunter2", "db-3"} {
+				if strings.Contains(sent, part) {
+					t.Errorf("%v %s contains %q of the cause", name, sent, part)
+				}
+			}
+		}
+		if msg := errcode.UserMsg(err); msg != http.StatusText(err.Code().HTTPCode()) {
+			t.Errorf("expected the HTTP status text as the user message but got %v", msg)
+		}
+		if !strings.Contains(err.Error(), secret) {
+			t.Errorf("expected the cause in Error() but got %v", err.Error())
+		}
+	}
+	jsonEquals(t, "ClientData", errcode.InternalClientData{Code: "internal.unimplemented", Msg: "Not Implemented"},
+		errcode.ClientData(errcode.NewUnimplementedErr(errors.New(secret))))
+}
+
 func TestStackTrace(t *testing.T) {
 	internalCodeStr := errcode.CodeStr("internal")
 	err := errors.New("errors stack")
@@ -225,7 +275,7 @@ func TestStackTrace(t *testing.T) {
 	AssertCode(t, wrappedInternalErr, internalCodeStr)
 	AssertHTTPCode(t, wrappedInternalErr, 500)
 	ErrorEquals(t, err, "errors stack")
-	ClientDataEquals(t, wrappedInternalErr, err, internalCodeStr)
+	ClientDataEquals(t, wrappedInternalErr, internalClientData, internalCodeStr)
 	// It should use the original stack trace, not the wrapped
 	AssertStackEquals(t, wrappedInternalErr, errcode.StackTrace(err))
 }
@@ -236,20 +286,20 @@ func TestNewInternalErr(t *testing.T) {
 	AssertCode(t, err, internalCodeStr)
 	AssertHTTPCode(t, err, 500)
 	ErrorEquals(t, err, "new error")
-	ClientDataEquals(t, err, errors.New("new error"), "internal")
+	ClientDataEquals(t, err, internalClientData, "internal")
 
 	err = errcode.NewInternalErr(MinimalError{})
 	AssertCode(t, err, internalCodeStr)
 	AssertHTTPCode(t, err, 500)
 	ErrorEquals(t, err, "error")
-	ClientDataEquals(t, err, MinimalError{}, internalCodeStr)
+	ClientDataEquals(t, err, internalClientData, internalCodeStr)
 
 	invalidErr := errcode.NewInvalidInputErr(MinimalError{})
 	err = errcode.NewInternalErr(invalidErr)
 	AssertCode(t, err, internalCodeStr)
 	AssertHTTPCode(t, err, 500)
 	ErrorEquals(t, err, "error")
-	ClientDataEquals(t, err, MinimalError{}, internalCodeStr)
+	ClientDataEquals(t, err, internalClientData, internalCodeStr)
 }
 
 // Test Operation
@@ -327,6 +377,19 @@ func TestErrorCodeChain(t *testing.T) {
 	AssertCodeChain(t, multiErr, errcode.ChainContext{Top: multiErr, ErrCode: multiCode})
 }
 
+func TestCodeChainWrapped(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(errors.New("no user"))
+	ann := errors.Annotate(notFound, "lookup")
+	AssertCodeChain(t, ann, errcode.ChainContext{Top: ann, ErrCode: notFound})
+	AssertCode(t, errcode.CodeChain(ann), errcode.NotFoundCode.CodeStr())
+
+	wrapped := fmt.Errorf("handler: %w", ann)
+	AssertCodeChain(t, wrapped, errcode.ChainContext{Top: wrapped, ErrCode: notFound})
+	if cause := errcode.CodeChain(wrapped).(errcode.Causer).Cause(); cause != ann {
+		t.Errorf("expected cause %v but got %v", ann, cause)
+	}
+}
+
 func AssertCodeChain(t *testing.T, input error, expected errcode.ErrorCode) {
 	t.Helper()
 	output := errcode.CodeChain(input)
@@ -368,6 +431,7 @@ func ErrorEquals(t *testing.T, err error, msg string) {
 
 func ClientDataEquals(t *testing.T, code errcode.ErrorCode, data interface{}, codeStrs ...errcode.CodeStr) {
 	codeStr := codeString
+	msg := code.Error()
 	var stack errors.StackTrace
 	if len(codeStrs) > 0 {
 		codeStr = codeStrs[0]
@@ -375,14 +439,18 @@ func ClientDataEquals(t *testing.T, code errcode.ErrorCode, data interface{}, co
 			stack = errcode.StackTrace(code)
 		}
 	}
+	if internalData, ok := data.(errcode.InternalClientData); ok {
+		msg = internalData.Msg
+	}
 	t.Helper()
 
 	jsonEquals(t, "ClientData", data, errcode.ClientData(code))
 
 	jsonExpected := errcode.JSONFormat{
 		Data:      data,
-		Msg:       code.Error(),
+		Msg:       msg,
 		Code:      codeStr,
+		Category:  code.Code().Category(),
 		Operation: errcode.Operation(data),
 		Stack:     stack,
 	}
@@ -425,3 +493,2060 @@ func AssertStackEquals(t *testing.T, given errcode.ErrorCode, stExpected errors.
 		t.Errorf("\nStack expected: %#v\n Stack but got: %#v", stExpected[0], stGiven[0])
 	}
 }
+
+func panicForbidden() error {
+	panic(errcode.NewForbiddenErr(fmt.Errorf("no access")))
+}
+
+func TestGuardCode(t *testing.T) {
+	err := errcode.GuardCode(func() error { return nil })
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	err = errcode.GuardCode(panicForbidden)
+	errCode, ok := err.(errcode.ErrorCode)
+	if !ok {
+		t.Fatalf("expected an ErrorCode, got %T", err)
+	}
+	AssertCode(t, errCode, errcode.ForbiddenCode.CodeStr())
+	AssertHTTPCode(t, errCode, 403)
+	ErrorEquals(t, errCode, "no access")
+	stack := errcode.StackTrace(errCode)
+	if len(stack) == 0 {
+		t.Fatal("expected a stack trace")
+	}
+	if frame := fmt.Sprintf("%n", stack[0]); frame != "panicForbidden" {
+		t.Errorf("expected the stack to start at the panic, got %v", frame)
+	}
+
+	err = errcode.GuardCode(func() error { panic("plain panic") })
+	errCode = err.(errcode.ErrorCode)
+	AssertCode(t, errCode, errcode.InternalCode.CodeStr())
+	ErrorEquals(t, errCode, "panic: plain panic")
+	if errcode.StackTrace(errCode) == nil {
+		t.Error("expected a stack trace")
+	}
+}
+
+var timeoutChildCodeStr errcode.CodeStr = "timeout.upstream"
+var timeoutChild = errcode.TimeoutCode.Child(timeoutChildCodeStr)
+
+type TimeoutChild struct{}
+
+func (tc TimeoutChild) Error() string      { return "upstream timed out" }
+func (tc TimeoutChild) Code() errcode.Code { return timeoutChild }
+
+func TestNewTimeoutErr(t *testing.T) {
+	timeoutCodeStr := errcode.CodeStr("timeout")
+	err := errcode.NewTimeoutErr(errors.New("took too long"))
+	AssertCode(t, err, timeoutCodeStr)
+	AssertHTTPCode(t, err, 504)
+	ErrorEquals(t, err, "took too long")
+	ClientDataEquals(t, err, errors.New("took too long"), timeoutCodeStr)
+
+	err = errcode.NewTimeoutErr(TimeoutChild{})
+	AssertCode(t, err, timeoutChildCodeStr)
+	AssertHTTPCode(t, err, 504)
+	ErrorEquals(t, err, "upstream timed out")
+	ClientDataEquals(t, err, TimeoutChild{}, timeoutChildCodeStr)
+
+	err = errcode.NewTimeoutErr(MinimalError{})
+	AssertCode(t, err, timeoutCodeStr)
+	AssertHTTPCode(t, err, 504)
+}
+
+func TestLogFields(t *testing.T) {
+	cause := errors.New("log me")
+	fields := errcode.LogFields(errcode.NewInternalErr(cause))
+	expected := []errcode.LogField{
+		{Key: "code", Value: errcode.CodeStr("internal")},
+		{Key: "msg", Value: "log me"},
+		{Key: "http", Value: 500},
+		{Key: "cause", Value: "log me"},
+		{Key: "stack", Value: errcode.StackTrace(cause)},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("LogFields expected %#v\ngot %#v", expected, fields)
+	}
+
+	fields = errcode.LogFields(MinimalError{})
+	expected = []errcode.LogField{
+		{Key: "code", Value: codeString},
+		{Key: "msg", Value: "error"},
+		{Key: "http", Value: 400},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("LogFields expected %#v\ngot %#v", expected, fields)
+	}
+
+	// An ErrorCode value that is not comparable
+	aggregate := errcode.NewAggregateErrCode(errcode.InvalidInputCode,
+		errcode.NewNotFoundErr(errors.New("first")), errcode.NewNotFoundErr(errors.New("second")))
+	fields = errcode.LogFields(aggregate)
+	for _, field := range fields {
+		if field.Key == "cause" {
+			t.Errorf("expected no cause for an error that does not wrap but got %v", field.Value)
+		}
+	}
+}
+
+func TestToFields(t *testing.T) {
+	err := errcode.Op("load").AddTo(errcode.NewInternalErr(errors.New("disk full")))
+	expected := map[string]interface{}{
+		"code":        errcode.CodeStr("internal"),
+		"http_status": 500,
+		"msg":         "load: disk full",
+		"operations":  []string{"load"},
+		"retryable":   true,
+		"client_data": internalClientData,
+	}
+	if fields := errcode.ToFields(err); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("ToFields expected %#v\ngot %#v", expected, fields)
+	}
+
+	expected = map[string]interface{}{
+		"code":        errcode.NotFoundCode.CodeStr(),
+		"http_status": 404,
+		"msg":         "not found",
+	}
+	if fields := errcode.ToFields(errcode.ErrNotFound); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("ToFields expected %#v\ngot %#v", expected, fields)
+	}
+}
+
+func TestNewUnavailableErr(t *testing.T) {
+	unavailableCodeStr := errcode.CodeStr("unavailable")
+	err := errcode.NewUnavailableErr(errors.New("down"))
+	AssertCode(t, err, unavailableCodeStr)
+	AssertHTTPCode(t, err, 503)
+	ErrorEquals(t, err, "down")
+	ClientDataEquals(t, err, errors.New("down"), unavailableCodeStr)
+
+	if errcode.UnavailableCode.IsAncestor(errcode.InternalCode) {
+		t.Error("UnavailableCode should not be an InternalCode")
+	}
+	err = errcode.NewInternalErr(err)
+	AssertCode(t, err, "internal")
+}
+
+var retryableParent = errcode.NewCode("retryparent").SetRetryable(true)
+var retryableChild = retryableParent.Child("retryparent.child")
+var notRetryableChild = retryableParent.Child("retryparent.never").SetRetryable(false)
+
+func TestRetryable(t *testing.T) {
+	AssertRetryable(t, errcode.InternalCode, true)
+	AssertRetryable(t, errcode.UnavailableCode, true)
+	AssertRetryable(t, errcode.UnimplementedCode, false)
+	AssertRetryable(t, errcode.InvalidInputCode, false)
+	AssertRetryable(t, errcode.NotFoundCode, false)
+	AssertRetryable(t, retryableParent, true)
+	AssertRetryable(t, retryableChild, true)
+	AssertRetryable(t, notRetryableChild, false)
+
+	if !errcode.IsRetryable(errcode.NewUnavailableErr(errors.New("down"))) {
+		t.Error("expected unavailable error to be retryable")
+	}
+	if errcode.IsRetryable(MinimalError{}) {
+		t.Error("expected input error to not be retryable")
+	}
+}
+
+func AssertRetryable(t *testing.T, code errcode.Code, retryable bool) {
+	t.Helper()
+	if code.IsRetryable() != retryable {
+		t.Errorf("expected %v retryable %v", code.CodeStr(), retryable)
+	}
+}
+
+type CustomJSONData struct{ Secret string }
+
+func (d *CustomJSONData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"custom": "shape"})
+}
+
+type CustomJSONError struct{ Data CustomJSONData }
+
+func (e CustomJSONError) Error() string              { return "custom json" }
+func (e CustomJSONError) Code() errcode.Code         { return registeredCode }
+func (e CustomJSONError) GetClientData() interface{} { return e.Data }
+
+func TestJSONMarshalerClientData(t *testing.T) {
+	err := CustomJSONError{Data: CustomJSONData{Secret: "hidden"}}
+	got, marshalErr := json.Marshal(errcode.NewJSONFormat(err))
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+	expected := `{"code":"input.testcode","category":"input","msg":"custom json","data":{"custom":"shape"}}`
+	if string(got) != expected {
+		t.Errorf("expected %v\ngot %v", expected, string(got))
+	}
+}
+
+var overrideCode = errcode.InvalidInputCode.Child("input.override").SetHTTP(http.StatusBadRequest)
+var forceUnmappedCode = errcode.NewCode("forceunmapped")
+
+func TestForceSetHTTP(t *testing.T) {
+	AssertPanics(t, "SetHTTP", func() { overrideCode.SetHTTP(http.StatusTeapot) })
+	if overrideCode.HTTPCode() != http.StatusBadRequest {
+		t.Errorf("expected SetHTTP to not change the HTTP code")
+	}
+
+	overrideCode.ForceSetHTTP(http.StatusTeapot)
+	if overrideCode.HTTPCode() != http.StatusTeapot {
+		t.Errorf("expected ForceSetHTTP to change the HTTP code, got %v", overrideCode.HTTPCode())
+	}
+
+	// restore for repeated test runs
+	overrideCode.ForceSetHTTP(http.StatusBadRequest)
+
+	forceUnmappedCode.ForceSetHTTP(http.StatusConflict)
+	if forceUnmappedCode.HTTPCode() != http.StatusConflict {
+		t.Errorf("expected ForceSetHTTP to set an unset HTTP code, got %v", forceUnmappedCode.HTTPCode())
+	}
+}
+
+var (
+	typoHTTPCode   = errcode.NewCode("typohttp")
+	teapotHTTPCode = errcode.NewCode("teapothttp").SetHTTP(http.StatusTeapot)
+)
+
+func TestSetHTTPRange(t *testing.T) {
+	AssertPanics(t, "SetHTTP(4004)", func() { typoHTTPCode.SetHTTP(4004) })
+	AssertPanics(t, "SetHTTP(99)", func() { typoHTTPCode.SetHTTP(99) })
+	AssertPanics(t, "ForceSetHTTP(600)", func() { typoHTTPCode.ForceSetHTTP(600) })
+	if typoHTTPCode.HasExplicitHTTP() {
+		t.Errorf("expected an invalid HTTP code to not be set")
+	}
+	if teapotHTTPCode.HTTPCode() != http.StatusTeapot {
+		t.Errorf("expected SetHTTP(418) to succeed, got %v", teapotHTTPCode.HTTPCode())
+	}
+
+	if _, err := errcode.NewCodeBuilder("typobuilt").HTTP(4004).TryBuild(); err == nil {
+		t.Errorf("expected an error for an invalid HTTP code")
+	}
+	if _, ok := errcode.LookupCode("typobuilt"); ok {
+		t.Errorf("expected a failed build not to register the code")
+	}
+}
+
+func AssertPanics(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected %v to panic", name)
+		}
+	}()
+	fn()
+}
+
+func TestNewPaymentRequiredErr(t *testing.T) {
+	paymentCodeStr := errcode.CodeStr("auth.forbidden.payment")
+	err := errcode.NewPaymentRequiredErr("trial_expired")
+	AssertCode(t, err, paymentCodeStr)
+	AssertHTTPCode(t, err, 402)
+	ErrorEquals(t, err, "payment required: trial_expired")
+	ClientDataEquals(t, err, map[string]string{"reason": "trial_expired"}, paymentCodeStr)
+	if !err.Code().IsAncestor(errcode.ForbiddenCode) {
+		t.Error("expected PaymentRequiredCode to be a ForbiddenCode")
+	}
+}
+
+func TestNewAlreadyExistsErr(t *testing.T) {
+	existsCodeStr := errcode.AlreadyExistsCode.CodeStr()
+	err := errcode.NewAlreadyExistsErr(errors.New("user exists"), "user", "alice")
+	AssertCode(t, err, existsCodeStr)
+	AssertHTTPCode(t, err, 409)
+	ErrorEquals(t, err, "user exists")
+	ClientDataEquals(t, err, errcode.Conflict{Resource: "user", Key: "alice"}, existsCodeStr)
+	if conflict, ok := errcode.ConflictDetail(errors.Annotate(err, "create")); !ok || conflict != (errcode.Conflict{Resource: "user", Key: "alice"}) {
+		t.Errorf("expected the conflict but got %v %v", conflict, ok)
+	}
+
+	jsonEquals(t, "ClientData", map[string]string{"key": "alice"}, errcode.ClientData(errcode.NewAlreadyExistsErr(errors.New("exists"), "", "alice")))
+	jsonEquals(t, "ClientData", map[string]string{}, errcode.ClientData(errcode.NewAlreadyExistsErr(errors.New("exists"), "", "")))
+	if _, ok := errcode.ConflictDetail(errcode.NewNotFoundErr(errors.New("missing"))); ok {
+		t.Error("expected no conflict")
+	}
+}
+
+func TestNewInvalidInputErrFields(t *testing.T) {
+	fields := []errcode.ValidationError{
+		{Field: "name", Reason: "required"},
+		{Field: "age", Reason: "must be positive"},
+		{Field: "email", Reason: "invalid format"},
+	}
+	err := errcode.NewInvalidInputErrFields(fields...)
+	AssertCode(t, err, errcode.InvalidInputCode.CodeStr())
+	AssertHTTPCode(t, err, 400)
+	ErrorEquals(t, err, "invalid input: name: required; age: must be positive; email: invalid format")
+	ClientDataEquals(t, err, map[string]interface{}{"fields": []map[string]string{
+		{"field": "name", "reason": "required"},
+		{"field": "age", "reason": "must be positive"},
+		{"field": "email", "reason": "invalid format"},
+	}}, errcode.InvalidInputCode.CodeStr())
+	if got := errcode.FieldErrors(errors.Annotate(err, "signup")); !reflect.DeepEqual(got, fields) {
+		t.Errorf("expected fields %v but got %v", fields, got)
+	}
+	if got := errcode.FieldErrors(fields[0]); !reflect.DeepEqual(got, fields[:1]) {
+		t.Errorf("expected field %v but got %v", fields[0], got)
+	}
+	jsonEquals(t, "ClientData", map[string]interface{}{"fields": []string{}}, errcode.ClientData(errcode.NewInvalidInputErrFields()))
+}
+
+func TestNewOutOfRangeErr(t *testing.T) {
+	err := errcode.NewOutOfRangeErr(errors.New("offset past the end"), 0, 99, 150)
+	AssertCode(t, err, errcode.OutOfRangeCode.CodeStr())
+	AssertHTTPCode(t, err, 400)
+	ErrorEquals(t, err, "offset past the end")
+	ClientDataEquals(t, err, errcode.RangeBounds{Min: 0, Max: 99, Actual: 150}, errcode.OutOfRangeCode.CodeStr())
+	jsonEquals(t, "ClientData", json.RawMessage(`{"min":0,"max":99,"actual":150}`), errcode.ClientData(err))
+
+	open := errcode.NewOutOfRangeErr(errors.New("negative"), 0, nil, -1)
+	jsonEquals(t, "ClientData", json.RawMessage(`{"min":0,"actual":-1}`), errcode.ClientData(open))
+}
+
+func TestWithMaxRetries(t *testing.T) {
+	if _, ok := errcode.MaxRetries(MinimalError{}); ok {
+		t.Error("expected no retry budget")
+	}
+
+	unavailable := errcode.NewUnavailableErr(errors.New("down"))
+	err := errcode.WithMaxRetries(unavailable, 3)
+	AssertCode(t, err, "unavailable")
+	ErrorEquals(t, err, "down")
+	if n, ok := errcode.MaxRetries(errors.Annotate(err, "annotated")); !ok || n != 3 {
+		t.Errorf("expected a retry budget of 3, got %v %v", n, ok)
+	}
+	jsonEquals(t, "ClientData", errcode.RetryClientData{Data: errors.New("down"), MaxRetries: 3}, errcode.ClientData(err))
+	jsonEquals(t, "ClientData", map[string]interface{}{"data": map[string]interface{}{}, "maxRetries": 3}, errcode.ClientData(err))
+
+	err = errcode.WithMaxRetries(MinimalError{}, 3)
+	ClientDataEquals(t, err, MinimalError{})
+}
+
+func TestDuplicateCode(t *testing.T) {
+	AssertPanics(t, "NewCode", func() { errcode.NewCode("internal") })
+	AssertPanics(t, "Child", func() { errcode.InvalidInputCode.Child("input.testcode") })
+	AssertPanics(t, "Child", func() { errcode.InvalidInputCode.Child("testcode") })
+
+	codes := errcode.RegisteredCodes()
+	for _, code := range []errcode.Code{errcode.InternalCode, errcode.AlreadyExistsCode, registeredCode, deepCode} {
+		if registered, ok := codes[code.CodeStr()]; !ok || registered.CodeStr() != code.CodeStr() {
+			t.Errorf("expected %v to be registered", code.CodeStr())
+		}
+	}
+	delete(codes, errcode.InternalCode.CodeStr())
+	if _, ok := errcode.RegisteredCodes()[errcode.InternalCode.CodeStr()]; !ok {
+		t.Error("expected RegisteredCodes to return a copy")
+	}
+}
+
+func TestChildren(t *testing.T) {
+	AssertIncludesCodes(t, errcode.StateCode.Children(), errcode.AlreadyExistsCode, errcode.OutOfRangeCode)
+	AssertIncludesCodes(t, errcode.AuthCode.Children(), errcode.ForbiddenCode, errcode.NotAuthenticatedCode)
+	AssertIncludesCodes(t, errcode.AuthCode.Descendants(), errcode.ForbiddenCode, errcode.PaymentRequiredCode)
+	for _, children := range [][]errcode.Code{errcode.StateCode.Children(), errcode.AuthCode.Descendants()} {
+		sorted := sort.SliceIsSorted(children, func(i, j int) bool {
+			return children[i].CodeStr() < children[j].CodeStr()
+		})
+		if !sorted {
+			t.Errorf("expected codes to be sorted")
+		}
+	}
+	AssertCodeStrs(t, registeredCode.Children(), "input.testcode.very")
+	AssertCodeStrs(t, registeredCode.Descendants(), "input.testcode.very", "input.testcode.very.very", deepCodeStr)
+	AssertCodeStrs(t, deepCode.Children())
+	AssertCodeStrs(t, deepCode.Descendants())
+}
+
+func AssertIncludesCodes(t *testing.T, codes []errcode.Code, expected ...errcode.Code) {
+	t.Helper()
+	for _, code := range expected {
+		found := false
+		for _, got := range codes {
+			if got.CodeStr() == code.CodeStr() {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected %v to be included", code.CodeStr())
+		}
+	}
+}
+
+func AssertCodeStrs(t *testing.T, codes []errcode.Code, expected ...errcode.CodeStr) {
+	t.Helper()
+	got := make([]errcode.CodeStr, len(codes))
+	for i, code := range codes {
+		got[i] = code.CodeStr()
+	}
+	if expected == nil {
+		expected = []errcode.CodeStr{}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected codes %v but got %v", expected, got)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	multi := errcode.Combine(MinimalError{}, notFound, MinimalError{}, notFound, MinimalError{}, TopError{})
+	deduped := multi.Dedup()
+	expected := []error{
+		errcode.DuplicateErrCode{Err: MinimalError{}, Count: 3},
+		errcode.DuplicateErrCode{Err: notFound, Count: 2},
+		TopError{},
+	}
+	if !reflect.DeepEqual(deduped.Errors(), expected) {
+		t.Errorf("Dedup expected %#v\ngot %#v", expected, deduped.Errors())
+	}
+	AssertCodes(t, deduped)
+	ErrorEquals(t, deduped, "error (3 times); missing (2 times); error")
+
+	// The count is given in the client data of an aggregate and in the others of a group
+	aggregate := errcode.NewAggregateErrCode(errcode.InvalidInputCode, errcode.ErrorCodes(deduped)...)
+	formats := errcode.ClientData(aggregate).([]errcode.JSONFormat)
+	if len(formats) != 3 || formats[0].Count != 3 || formats[1].Count != 2 || formats[2].Count != 0 {
+		t.Errorf("expected the counts 3, 2, and 0 but got %#v", formats)
+	}
+	if encoded, err := json.Marshal(formats[2]); err != nil || strings.Contains(string(encoded), "count") {
+		t.Errorf("expected no count for a unique error but got %s", encoded)
+	}
+	if others := errcode.NewJSONFormat(deduped).Others; len(others) != 2 || others[0].Count != 2 {
+		t.Errorf("expected the count in the others of the group but got %#v", others)
+	}
+	if count := errcode.DuplicateCount(errcode.WithOperation(errcode.DuplicateErrCode{Err: notFound, Count: 4}, "load")); count != 4 {
+		t.Errorf("expected the count through a wrapper but got %v", count)
+	}
+
+	unique := errcode.Combine(MinimalError{}, TopError{})
+	if !reflect.DeepEqual(unique.Dedup(), unique) {
+		t.Errorf("expected Dedup to not change unique errors")
+	}
+}
+
+func TestLookupCode(t *testing.T) {
+	for _, expected := range []errcode.Code{errcode.InternalCode, errcode.ForbiddenCode, deepCode} {
+		code, ok := errcode.LookupCode(expected.CodeStr())
+		if !ok {
+			t.Fatalf("expected to find %v", expected.CodeStr())
+		}
+		if code.CodeStr() != expected.CodeStr() || code.HTTPCode() != expected.HTTPCode() {
+			t.Errorf("expected %v but got %v", expected.CodeStr(), code.CodeStr())
+		}
+	}
+	if _, ok := errcode.LookupCode("not.registered"); ok {
+		t.Error("expected an unregistered code to not be found")
+	}
+	if _, ok := errcode.LookupCode("very"); ok {
+		t.Error("expected a partial code path to not be found")
+	}
+}
+
+func TestAggregateErrCode(t *testing.T) {
+	empty := errcode.NewAggregateErrCode(errcode.InvalidInputCode)
+	AssertCode(t, empty, "input")
+	ErrorEquals(t, empty, "input")
+	ClientDataEquals(t, empty, []interface{}{}, "input")
+
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	single := errcode.NewAggregateErrCode(errcode.InvalidInputCode, notFound)
+	AssertCodes(t, single, "input")
+	ErrorEquals(t, single, "missing")
+	ClientDataEquals(t, single, []errcode.JSONFormat{errcode.NewJSONFormat(notFound)}, "input")
+
+	aggregate := errcode.NewAggregateErrCode(errcode.InternalCode, MinimalError{}, notFound)
+	AssertCode(t, aggregate, "internal")
+	AssertHTTPCode(t, aggregate, 500)
+	ErrorEquals(t, aggregate, "error; missing")
+	expected := `[{"code":"input.testcode","category":"input","msg":"error","data":{}},{"code":"missing","category":"notfound","msg":"missing","data":{}}]`
+	data, err := json.Marshal(errcode.ClientData(aggregate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("expected %v\ngot %v", expected, string(data))
+	}
+}
+
+func TestCombineHTTP(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	exists := errcode.NewCodedError(fmt.Errorf("exists"), errcode.AlreadyExistsCode)
+	internal := errcode.NewInternalErr(fmt.Errorf("internal"))
+	forbidden := errcode.NewForbiddenErr(fmt.Errorf("forbidden"))
+	unavailable := errcode.NewUnavailableErr(fmt.Errorf("unavailable"))
+	AssertCombineHTTP(t, 0)
+	AssertCombineHTTP(t, 400, MinimalError{})
+	AssertCombineHTTP(t, 500, notFound, exists, internal)
+	AssertCombineHTTP(t, 500, unavailable, internal, notFound)
+	AssertCombineHTTP(t, 503, notFound, unavailable)
+	AssertCombineHTTP(t, 404, MinimalError{}, exists, notFound)
+	AssertCombineHTTP(t, 403, exists, forbidden, notFound)
+	AssertCombineHTTP(t, 409, exists, MinimalError{})
+	AssertCombineHTTP(t, 402, errcode.NewPaymentRequiredErr("trial_expired"), MinimalError{})
+	AssertCombineHTTP(t, 404, nil, notFound)
+}
+
+func AssertCombineHTTP(t *testing.T, expected int, errs ...errcode.ErrorCode) {
+	t.Helper()
+	if got := errcode.CombineHTTP(errs...); got != expected {
+		t.Errorf("expected CombineHTTP %v but got %v", expected, got)
+	}
+}
+
+func TestStackFormat(t *testing.T) {
+	for _, err := range []errcode.ErrorCode{
+		errcode.NewInternalErr(fmt.Errorf("format me")),
+		errcode.NewUnimplementedErr(fmt.Errorf("format me")),
+		errcode.NewStackCode(MinimalError{}),
+	} {
+		msg := err.Error()
+		if got := fmt.Sprintf("%v", err); got != msg {
+			t.Errorf("expected %%v to give %#v but got %#v", msg, got)
+		}
+		if got := fmt.Sprintf("%s", err); got != msg {
+			t.Errorf("expected %%s to give %#v but got %#v", msg, got)
+		}
+		verbose := fmt.Sprintf("%+v", err)
+		if !strings.HasPrefix(verbose, msg+"\n") {
+			t.Errorf("expected %%+v to start with the message but got %#v", verbose)
+		}
+		if !strings.Contains(verbose, "TestStackFormat") {
+			t.Errorf("expected %%+v to contain the stack but got %#v", verbose)
+		}
+	}
+}
+
+func TestStackTraceTopFrame(t *testing.T) {
+	for _, err := range []errcode.ErrorCode{
+		errcode.NewInternalErr(fmt.Errorf("top frame")),
+		errcode.NewUnimplementedErr(fmt.Errorf("top frame")),
+		errcode.NewStackCode(MinimalError{}),
+	} {
+		stack := errcode.StackTrace(err)
+		if len(stack) == 0 {
+			t.Fatal("expected a stack trace")
+		}
+		if name := fmt.Sprintf("%n", stack[0]); name != "TestStackTraceTopFrame" {
+			t.Errorf("expected the stack to start at the caller but got %v", name)
+		}
+	}
+}
+
+func TestNewIdempotencyConflictErr(t *testing.T) {
+	idempotencyCodeStr := errcode.CodeStr("state.exists.idempotency")
+	err := errcode.NewIdempotencyConflictErr("key-1")
+	AssertCode(t, err, idempotencyCodeStr)
+	AssertHTTPCode(t, err, 422)
+	ErrorEquals(t, err, "idempotency key reused with a different payload: key-1")
+	ClientDataEquals(t, err, map[string]string{"key": "key-1"}, idempotencyCodeStr)
+	if !err.Code().IsAncestor(errcode.AlreadyExistsCode) || !err.Code().IsAncestor(errcode.StateCode) {
+		t.Error("expected IdempotencyConflictCode to be an AlreadyExistsCode")
+	}
+}
+
+func wrapInternalErr(err error) errcode.ErrorCode {
+	return errcode.NewInternalErrDepth(err, 1)
+}
+
+func wrapStackCode(err error) errcode.ErrorCode {
+	return errcode.NewStackCodeDepth(err, 1)
+}
+
+func TestStackDepth(t *testing.T) {
+	for _, err := range []errcode.ErrorCode{
+		wrapInternalErr(fmt.Errorf("wrapped")),
+		wrapStackCode(MinimalError{}),
+		errcode.NewStackCodeDepth(MinimalError{}, 0),
+		errcode.NewInternalErrDepth(fmt.Errorf("not wrapped"), 0),
+		wrapStackCode(fmt.Errorf("plain")),
+		errcode.NewStackCodeDepth(fmt.Errorf("plain"), 0),
+	} {
+		stack := errcode.StackTrace(err)
+		if len(stack) == 0 {
+			t.Fatal("expected a stack trace")
+		}
+		if name := fmt.Sprintf("%n", stack[0]); name != "TestStackDepth" {
+			t.Errorf("expected the stack to start at the caller of the wrapper but got %v", name)
+		}
+	}
+
+	AssertCode(t, errcode.NewStackCodeDepth(fmt.Errorf("plain"), 0), "internal")
+	AssertCode(t, errcode.NewStackCodeDepth(fmt.Errorf("wrapped: %w", errcode.NewNotFoundErr(fmt.Errorf("missing"))), 0), "missing")
+	AssertPanics(t, "a nil error", func() { errcode.NewStackCodeDepth(nil, 0) })
+}
+
+func TestWithForceSample(t *testing.T) {
+	if errcode.IsForceSample(MinimalError{}) {
+		t.Error("expected no force sample")
+	}
+	err := errcode.WithForceSample(errcode.NewNotFoundErr(fmt.Errorf("missing")))
+	AssertCode(t, err, "missing")
+	AssertHTTPCode(t, err, 404)
+	ErrorEquals(t, err, "missing")
+	if !errcode.IsForceSample(errors.Annotate(err, "annotated")) {
+		t.Error("expected force sample")
+	}
+}
+
+func getUser(id int, err error) (string, error) {
+	return fmt.Sprintf("user %d", id), err
+}
+
+func TestResultHelpers(t *testing.T) {
+	user, errCode := errcode.Internal(getUser(1, nil))
+	if user != "user 1" || errCode != nil {
+		t.Errorf("expected the value and no error but got %v %v", user, errCode)
+	}
+	user, errCode = errcode.NotFound(getUser(2, nil))
+	if user != "user 2" || errCode != nil {
+		t.Errorf("expected the value and no error but got %v %v", user, errCode)
+	}
+
+	user, errCode = errcode.Internal(getUser(3, fmt.Errorf("db down")))
+	if user != "user 3" {
+		t.Errorf("expected the value to be passed through but got %v", user)
+	}
+	AssertCode(t, errCode, "internal")
+	ErrorEquals(t, errCode, "db down")
+	if name := fmt.Sprintf("%n", errcode.StackTrace(errCode)[0]); name != "TestResultHelpers" {
+		t.Errorf("expected the stack to start at the caller but got %v", name)
+	}
+
+	user, errCode = errcode.NotFound(getUser(4, fmt.Errorf("no user")))
+	if user != "user 4" {
+		t.Errorf("expected the value to be passed through but got %v", user)
+	}
+	AssertCode(t, errCode, "missing")
+
+	count, errCode := errcode.InvalidInput(5, fmt.Errorf("bad count"))
+	if count != 5 {
+		t.Errorf("expected the value to be passed through but got %v", count)
+	}
+	AssertCode(t, errCode, "input")
+}
+
+func TestWithUserMsg(t *testing.T) {
+	internal := errcode.NewInternalErr(fmt.Errorf("connection to db-3 refused"))
+	if msg := errcode.UserMsg(internal); msg != "Internal Server Error" {
+		t.Errorf("expected UserMsg to not use the cause of an internal error but got %v", msg)
+	}
+
+	err := errcode.WithUserMsg(internal, "please try again later")
+	AssertCode(t, err, "internal")
+	ErrorEquals(t, err, "connection to db-3 refused")
+	if errors.Cause(err) == nil || errcode.StackTrace(err) == nil {
+		t.Error("expected the cause and stack to be kept")
+	}
+	wrapped := errcode.Op("get").AddTo(err)
+	for _, errCode := range []errcode.ErrorCode{err, wrapped} {
+		if msg := errcode.UserMsg(errCode); msg != "please try again later" {
+			t.Errorf("expected the user message but got %v", msg)
+		}
+		if msg := errcode.NewJSONFormat(errCode).Msg; msg != "please try again later" {
+			t.Errorf("expected the client to get the user message but got %v", msg)
+		}
+	}
+	for _, field := range errcode.LogFields(err) {
+		if field.Key == "msg" && field.Value != "connection to db-3 refused" {
+			t.Errorf("expected logs to get the developer message but got %v", field.Value)
+		}
+	}
+}
+
+func TestWithOperation(t *testing.T) {
+	if ops := errcode.Operations(MinimalError{}); ops != nil {
+		t.Errorf("expected no operations but got %v", ops)
+	}
+
+	inner := errcode.WithOperation(MinimalError{}, "chargeCard")
+	err := errcode.WithOperation(inner, "CreateOrder")
+	AssertCodes(t, err)
+	ErrorEquals(t, err, "CreateOrder: chargeCard: error")
+	OpEquals(t, err, "CreateOrder")
+	if errors.Cause(err) != (MinimalError{}) {
+		t.Errorf("expected the cause to be kept")
+	}
+	expected := []string{"CreateOrder", "chargeCard"}
+	if ops := errcode.Operations(errors.Annotate(err, "annotated")); !reflect.DeepEqual(ops, expected) {
+		t.Errorf("expected operations %v but got %v", expected, ops)
+	}
+}
+
+func TestSeverityOrder(t *testing.T) {
+	ordered := []errcode.Severity{
+		errcode.SeverityDebug,
+		errcode.SeverityInfo,
+		errcode.SeverityWarn,
+		errcode.SeverityError,
+		errcode.SeverityCritical,
+	}
+	for i := range ordered {
+		for j := range ordered {
+			expected := ordered[i]
+			if j > i {
+				expected = ordered[j]
+			}
+			if got := errcode.MaxSeverity(ordered[i], ordered[j]); got != expected {
+				t.Errorf("expected MaxSeverity(%v, %v) to be %v but got %v", ordered[i], ordered[j], expected, got)
+			}
+		}
+	}
+	if errcode.SeverityWarn.String() != "warn" {
+		t.Errorf("unexpected severity name %v", errcode.SeverityWarn)
+	}
+}
+
+func TestHighestSeverity(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	internal := errcode.NewInternalErr(fmt.Errorf("internal"))
+	AssertHighestSeverity(t, errcode.SeverityDebug)
+	AssertHighestSeverity(t, errcode.SeverityInfo, notFound, MinimalError{})
+	AssertHighestSeverity(t, errcode.SeverityError, notFound, internal, nil)
+}
+
+func AssertHighestSeverity(t *testing.T, expected errcode.Severity, errs ...errcode.ErrorCode) {
+	t.Helper()
+	if got := errcode.HighestSeverity(errs); got != expected {
+		t.Errorf("expected HighestSeverity %v but got %v", expected, got)
+	}
+}
+
+func TestClientData(t *testing.T) {
+	// Without HasClientData the ErrorCode itself is the data
+	jsonEquals(t, "ClientData", TopError{}, errcode.ClientData(TopError{}))
+	jsonEquals(t, "ClientData", Struct2{A: "A", B: "B"}, errcode.ClientData(errcode.NewCodedError(Struct2{A: "A", B: "B"}, registeredCode)))
+
+	// A wrapped ErrorCode gives its own client data rather than the error value
+	nested := errcode.NewCodedError(ErrorWrapper{Err: Struct1{A: "nested"}}, errcode.InvalidInputCode)
+	jsonEquals(t, "ClientData", Struct1{A: "nested"}, errcode.ClientData(nested))
+	deeplyNested := errcode.NewNotFoundErr(errcode.NewCodedError(nested, errcode.InvalidInputCode))
+	jsonEquals(t, "ClientData", Struct1{A: "nested"}, errcode.ClientData(deeplyNested))
+	jsonEquals(t, "ClientData", Struct1{A: "nested"}, errcode.ClientData(errcode.Op("op").AddTo(deeplyNested)))
+	// An internal error does not give the client data of what it wraps
+	jsonEquals(t, "ClientData", internalClientData, errcode.ClientData(errcode.NewInternalErr(nested)))
+}
+
+func TestWithRemediation(t *testing.T) {
+	if steps := errcode.Remediation(MinimalError{}); steps != nil {
+		t.Errorf("expected no remediation but got %v", steps)
+	}
+	steps := []string{"log out", "log in again"}
+	err := errcode.WithRemediation(errcode.NewNotFoundErr(fmt.Errorf("missing")), steps)
+	AssertCode(t, err, "missing")
+	ErrorEquals(t, err, "missing")
+	if got := errcode.Remediation(errors.Annotate(err, "annotated")); !reflect.DeepEqual(got, steps) {
+		t.Errorf("expected remediation %v but got %v", steps, got)
+	}
+	expected := map[string]interface{}{"data": map[string]interface{}{}, "remediation": steps}
+	jsonEquals(t, "ClientData", expected, errcode.ClientData(err))
+}
+
+type exampleData struct {
+	ID int `json:"id"`
+}
+
+var exampleCode = errcode.NotFoundCode.Child("missing.example").SetExample(exampleData{ID: 7}).
+	SetDescription("An example was not found.")
+
+func TestExportCatalog(t *testing.T) {
+	catalog := errcode.ExportCatalog()
+	sorted := sort.SliceIsSorted(catalog, func(i, j int) bool {
+		return catalog[i].Code < catalog[j].Code
+	})
+	if !sorted {
+		t.Errorf("expected the catalog to be sorted")
+	}
+	entries := make(map[errcode.CodeStr]errcode.CatalogEntry)
+	for _, entry := range catalog {
+		entries[entry.Code] = entry
+	}
+	AssertCatalogJSON(t, entries[exampleCode.CodeStr()], `{"code":"missing.example","parent":"missing","http":404,"retryable":false,"description":"An example was not found.","example":{"id":7}}`)
+	AssertCatalogJSON(t, entries[errcode.InternalCode.CodeStr()], `{"code":"internal","http":500,"retryable":true}`)
+	if errcode.NotFoundCode.Example() != nil {
+		t.Errorf("expected examples to not be inherited")
+	}
+}
+
+func TestDumpCatalog(t *testing.T) {
+	first, err := errcode.DumpCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		again, err := errcode.DumpCatalog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, again) {
+			t.Fatalf("expected identical dumps\n%s\n%s", first, again)
+		}
+	}
+}
+
+func TestMetaDataEntries(t *testing.T) {
+	metaData := make(errcode.MetaData)
+	for _, code := range []errcode.Code{errcode.TimeoutCode, errcode.InternalCode, errcode.NotFoundCode, errcode.AuthCode} {
+		if err := code.SetMetaData(metaData, code.CodeStr().String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []errcode.MetaDataEntry{
+		{Code: "auth", Value: "auth"},
+		{Code: "internal", Value: "internal"},
+		{Code: "missing", Value: "missing"},
+		{Code: "timeout", Value: "timeout"},
+	}
+	for i := 0; i < 5; i++ {
+		if entries := metaData.Entries(); !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("expected entries %v but got %v", expected, entries)
+		}
+	}
+}
+
+func AssertCatalogJSON(t *testing.T, entry errcode.CatalogEntry, expected string) {
+	t.Helper()
+	got, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expected {
+		t.Errorf("expected catalog entry %v\ngot %v", expected, string(got))
+	}
+}
+
+var (
+	warnMissingCode  = errcode.NotFoundCode.Child("missing.warn").SetSeverity(errcode.SeverityWarn)
+	warnMissingChild = warnMissingCode.Child("missing.warn.child")
+	plainMissingCode = errcode.NotFoundCode.Child("missing.severity")
+)
+
+func TestCodeSeverity(t *testing.T) {
+	AssertSeverity(t, errcode.InternalCode, errcode.SeverityError)
+	AssertSeverity(t, errcode.NotFoundCode, errcode.SeverityInfo)
+	AssertSeverity(t, plainMissingCode, errcode.SeverityInfo)
+	AssertSeverity(t, warnMissingCode, errcode.SeverityWarn)
+	AssertSeverity(t, warnMissingChild, errcode.SeverityWarn)
+	// HTTP based default
+	AssertSeverity(t, errcode.UnavailableCode, errcode.SeverityError)
+	AssertSeverity(t, errcode.InvalidInputCode, errcode.SeverityInfo)
+
+	err := errcode.NewCodedError(errors.New("warn"), warnMissingCode)
+	if got := errcode.ErrorSeverity(err); got != errcode.SeverityWarn {
+		t.Errorf("expected ErrorSeverity %v but got %v", errcode.SeverityWarn, got)
+	}
+}
+
+func AssertSeverity(t *testing.T, code errcode.Code, expected errcode.Severity) {
+	t.Helper()
+	if got := code.Severity(); got != expected {
+		t.Errorf("expected severity of %v to be %v but got %v", code.CodeStr(), expected, got)
+	}
+}
+
+var (
+	replacementCode  = errcode.InvalidInputCode.Child("input.replacement")
+	deprecatedCode   = errcode.InvalidInputCode.Child("input.deprecated").SetHTTP(422).Deprecate(replacementCode)
+	selfReplacedCode = errcode.InvalidInputCode.Child("input.selfreplaced")
+)
+
+func TestDeprecate(t *testing.T) {
+	if !deprecatedCode.IsDeprecated() {
+		t.Errorf("expected %v to be deprecated", deprecatedCode.CodeStr())
+	}
+	replacement, ok := deprecatedCode.Replacement()
+	if !ok || replacement.CodeStr() != replacementCode.CodeStr() {
+		t.Errorf("expected replacement %v but got %v", replacementCode.CodeStr(), replacement.CodeStr())
+	}
+	AssertHTTPCode(t, errcode.NewCodedError(errors.New("deprecated"), deprecatedCode), 422)
+
+	if replacementCode.IsDeprecated() {
+		t.Errorf("expected %v to not be deprecated", replacementCode.CodeStr())
+	}
+	if _, ok := replacementCode.Replacement(); ok {
+		t.Errorf("expected no replacement for %v", replacementCode.CodeStr())
+	}
+	AssertCodeStrs(t, errcode.DeprecatedCodes(), deprecatedCode.CodeStr())
+
+	AssertPanics(t, "Deprecate with itself as the replacement", func() { selfReplacedCode.Deprecate(selfReplacedCode) })
+	if selfReplacedCode.IsDeprecated() {
+		t.Errorf("expected %v to not be deprecated", selfReplacedCode.CodeStr())
+	}
+}
+
+func TestHasExplicitHTTP(t *testing.T) {
+	if !errcode.NotFoundCode.HasExplicitHTTP() {
+		t.Errorf("expected NotFoundCode to have an explicit HTTP code")
+	}
+	if errcode.OutOfRangeCode.HasExplicitHTTP() {
+		t.Errorf("expected OutOfRangeCode to inherit its HTTP code")
+	}
+}
+
+func TestCodeEqual(t *testing.T) {
+	// The same code constructed with a different Parent pointer.
+	copied := errcode.OutOfRangeCode
+	parent := *copied.Parent
+	copied.Parent = &parent
+	if copied == errcode.OutOfRangeCode {
+		t.Errorf("expected == to compare Parent pointers")
+	}
+	if !copied.Equal(errcode.OutOfRangeCode) {
+		t.Errorf("expected codes with the same CodeStr to be Equal")
+	}
+	if !errcode.OutOfRangeCode.IsAncestor(copied) {
+		t.Errorf("expected IsAncestor to use Equal")
+	}
+	if errcode.OutOfRangeCode.Equal(errcode.StateCode) {
+		t.Errorf("expected a child to not Equal its parent")
+	}
+}
+
+func TestIsDescendant(t *testing.T) {
+	if !errcode.StateCode.IsDescendant(errcode.OutOfRangeCode) {
+		t.Errorf("expected OutOfRangeCode to be a descendant of StateCode")
+	}
+	if errcode.OutOfRangeCode.IsDescendant(errcode.StateCode) {
+		t.Errorf("expected StateCode to not be a descendant of OutOfRangeCode")
+	}
+	if errcode.NotFoundCode.IsDescendant(errcode.OutOfRangeCode) {
+		t.Errorf("expected unrelated codes to not be descendants")
+	}
+}
+
+func TestWrapRedacted(t *testing.T) {
+	cause := errors.New("password for db01 rejected")
+	err := errcode.WrapRedacted(cause, errcode.InvalidInputCode, "invalid credentials")
+	AssertCode(t, err, "input")
+	ErrorEquals(t, err, "invalid credentials")
+	ClientDataEquals(t, err, nil, "input")
+	if errors.Cause(err) != cause {
+		t.Errorf("expected the original error as the cause")
+	}
+	fields := errcode.LogFields(err)
+	expected := errcode.LogField{Key: "cause", Value: "password for db01 rejected"}
+	if !reflect.DeepEqual(fields[3], expected) {
+		t.Errorf("expected LogFields to include %#v\ngot %#v", expected, fields)
+	}
+	AssertPanics(t, "nil error", func() { errcode.WrapRedacted(nil, errcode.InvalidInputCode, "safe") })
+}
+
+func TestNewDegradedErr(t *testing.T) {
+	degradedCodeStr := errcode.CodeStr("state.degraded")
+	err := errcode.NewDegradedErr("recommendations")
+	AssertCode(t, err, degradedCodeStr)
+	AssertHTTPCode(t, err, 203)
+	ErrorEquals(t, err, "degraded: recommendations is unavailable")
+	ClientDataEquals(t, err, map[string]string{"feature": "recommendations"}, degradedCodeStr)
+	if !err.Code().IsAncestor(errcode.StateCode) {
+		t.Error("expected DegradedCode to be a StateCode")
+	}
+}
+
+// concurrentCodes gives unique code names across repeated test runs.
+var concurrentCodes int64
+
+// Run with -race to check that registration is safe.
+func TestConcurrentRegistration(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := atomic.AddInt64(&concurrentCodes, 1)
+			code := errcode.InvalidInputCode.Child(errcode.CodeStr(fmt.Sprintf("input.concurrent%d", n)))
+			code.SetHTTP(422).SetSeverity(errcode.SeverityWarn)
+			if code.HTTPCode() != 422 || code.Severity() != errcode.SeverityWarn {
+				t.Errorf("unexpected meta data for %v", code.CodeStr())
+			}
+			if _, ok := errcode.LookupCode(code.CodeStr()); !ok {
+				t.Errorf("expected %v to be registered", code.CodeStr())
+			}
+			_ = errcode.InvalidInputCode.Descendants()
+		}()
+	}
+	wg.Wait()
+}
+
+var goneCode = errcode.NotFoundCode.Child("missing.gone").SetHTTP(http.StatusGone)
+
+func TestResetHTTP(t *testing.T) {
+	goneCode.ResetHTTP()
+	if goneCode.HTTPCode() != http.StatusNotFound {
+		t.Errorf("expected ResetHTTP to inherit the parent HTTP code, got %v", goneCode.HTTPCode())
+	}
+	if goneCode.HasExplicitHTTP() {
+		t.Errorf("expected ResetHTTP to remove the explicit HTTP code")
+	}
+	// SetHTTP no longer panics once cleared, this also restores for repeated test runs
+	goneCode.SetHTTP(http.StatusGone)
+	if goneCode.HTTPCode() != http.StatusGone {
+		t.Errorf("expected SetHTTP after ResetHTTP to set the HTTP code, got %v", goneCode.HTTPCode())
+	}
+
+	forceUnmappedCode.ForceSetHTTP(http.StatusConflict).ResetHTTP()
+	AssertHTTPCode(t, errcode.NewCodedError(errors.New("reset"), forceUnmappedCode), http.StatusBadRequest)
+}
+
+func TestCategory(t *testing.T) {
+	expected := map[errcode.Code]errcode.Category{
+		errcode.InternalCode:        errcode.CategoryInternal,
+		errcode.UnimplementedCode:   errcode.CategoryInternal,
+		errcode.NotFoundCode:        errcode.CategoryNotFound,
+		errcode.OutOfRangeCode:      errcode.CategoryState,
+		errcode.InvalidInputCode:    errcode.CategoryInput,
+		errcode.PaymentRequiredCode: errcode.CategoryAuth,
+		errcode.TimeoutCode:         errcode.CategoryTimeout,
+		errcode.RateLimitCode:       errcode.CategoryRateLimit,
+		forceUnmappedCode:           errcode.CategoryUnknown,
+	}
+	for code, category := range expected {
+		if got := code.Category(); got != category {
+			t.Errorf("expected category of %v to be %v but got %v", code.CodeStr(), category, got)
+		}
+	}
+	jsonEquals(t, "category", "auth", errcode.NewJSONFormat(errcode.NewForbiddenErr(errors.New("no"))).Category)
+}
+
+func TestCodeForHTTP(t *testing.T) {
+	expected := map[int]errcode.Code{
+		http.StatusBadRequest:          errcode.InvalidInputCode,
+		http.StatusUnauthorized:        errcode.NotAuthenticatedCode,
+		http.StatusPaymentRequired:     errcode.PaymentRequiredCode,
+		http.StatusForbidden:           errcode.ForbiddenCode,
+		http.StatusNotFound:            errcode.NotFoundCode,
+		http.StatusRequestTimeout:      errcode.TimeoutCode,
+		http.StatusConflict:            errcode.AlreadyExistsCode,
+		http.StatusTooManyRequests:     errcode.RateLimitCode,
+		http.StatusTeapot:              errcode.InvalidInputCode,
+		http.StatusInternalServerError: errcode.InternalCode,
+		http.StatusNotImplemented:      errcode.UnimplementedCode,
+		http.StatusBadGateway:          errcode.InternalCode,
+		http.StatusServiceUnavailable:  errcode.UnavailableCode,
+		http.StatusGatewayTimeout:      errcode.TimeoutCode,
+		599:                            errcode.InternalCode,
+	}
+	for status, code := range expected {
+		if got := errcode.CodeForHTTP(status); !got.Equal(code) {
+			t.Errorf("expected CodeForHTTP(%v) to be %v but got %v", status, code.CodeStr(), got.CodeStr())
+		}
+	}
+	if rateLimit := errcode.NewRateLimitErr(errors.New("slow down")); !errcode.IsRetryable(rateLimit) || !rateLimit.Code().NoCache() {
+		t.Errorf("expected a rate limit to be retryable and not cached")
+	}
+}
+
+var (
+	slaExemptCode = errcode.InternalCode.Child("internal.slaexempt").SetSLAImpacting(false)
+	slaExemptLeaf = slaExemptCode.Child("internal.slaexempt.leaf")
+	slaInputCode  = errcode.InvalidInputCode.Child("input.sla").SetSLAImpacting(true)
+)
+
+func TestIsSLAImpacting(t *testing.T) {
+	impacting := map[errcode.Code]bool{
+		errcode.InternalCode:     true,
+		errcode.UnavailableCode:  true,
+		errcode.NotFoundCode:     false,
+		errcode.InvalidInputCode: false,
+		slaExemptCode:            false,
+		slaExemptLeaf:            false,
+		slaInputCode:             true,
+	}
+	for code, expected := range impacting {
+		if got := code.IsSLAImpacting(); got != expected {
+			t.Errorf("expected IsSLAImpacting of %v to be %v", code.CodeStr(), expected)
+		}
+	}
+
+	if errcode.IsSLAImpacting(nil) {
+		t.Errorf("expected a nil error to not be SLA impacting")
+	}
+	if !errcode.IsSLAImpacting(errors.New("plain")) {
+		t.Errorf("expected an error without a code to be SLA impacting")
+	}
+	if errcode.IsSLAImpacting(errors.Annotate(errcode.NewNotFoundErr(errors.New("missing")), "wrapped")) {
+		t.Errorf("expected a wrapped NotFound error to not be SLA impacting")
+	}
+}
+
+func TestRegistryMerge(t *testing.T) {
+	billing := errcode.NewRegistry()
+	users := errcode.NewRegistry()
+	for _, err := range []error{
+		billing.Register(errcode.PaymentRequiredCode),
+		billing.Register(errcode.IdempotencyConflictCode),
+		users.Register(errcode.NotAuthenticatedCode),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := users.Register(errcode.NotAuthenticatedCode); err == nil {
+		t.Errorf("expected an error registering a code twice")
+	}
+
+	if errs := billing.Merge(users); len(errs) != 0 {
+		t.Fatalf("expected no merge errors, got %v", errs)
+	}
+	AssertRegistryCodes(t, billing, "auth.forbidden.payment", "auth.unauthenticated", "state.exists.idempotency")
+
+	conflicting := errcode.NewRegistry()
+	if err := conflicting.Register(errcode.PaymentRequiredCode); err != nil {
+		t.Fatal(err)
+	}
+	if err := conflicting.Register(errcode.TimeoutCode); err != nil {
+		t.Fatal(err)
+	}
+	errs := users.Merge(conflicting)
+	if len(errs) != 0 {
+		t.Fatalf("expected no merge errors, got %v", errs)
+	}
+	errs = billing.Merge(conflicting)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "auth.forbidden.payment") {
+		t.Errorf("expected a collision for auth.forbidden.payment, got %v", errs)
+	}
+	// Nothing is added on a conflict
+	AssertRegistryCodes(t, billing, "auth.forbidden.payment", "auth.unauthenticated", "state.exists.idempotency")
+}
+
+func AssertRegistryCodes(t *testing.T, registry *errcode.Registry, expected ...errcode.CodeStr) {
+	t.Helper()
+	codes := registry.Codes()
+	got := make([]errcode.CodeStr, 0, len(codes))
+	for codeStr := range codes {
+		got = append(got, codeStr)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected registry codes %v but got %v", expected, got)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if _, ok := errcode.FromContext(context.Background()); ok {
+		t.Errorf("expected no ErrorCode in a bare context")
+	}
+	err := errcode.NewNotFoundErr(errors.New("missing"))
+	ctx := errcode.NewContext(context.Background(), err)
+	got, ok := errcode.FromContext(ctx)
+	if !ok || got != err {
+		t.Errorf("expected the ErrorCode from the context, got %v", got)
+	}
+}
+
+var chainOuterCode = errcode.NewCode("chainouter")
+
+func TestFirstExplicitHTTPCode(t *testing.T) {
+	inner := errcode.NewNotFoundErr(errors.New("missing"))
+	outer := errcode.WrapRedacted(errors.Annotate(inner, "lookup"), chainOuterCode, "not available")
+	AssertHTTPCode(t, outer, http.StatusBadRequest)
+	if got := errcode.FirstExplicitHTTPCode(outer); got != http.StatusNotFound {
+		t.Errorf("expected the inner 404 to win but got %v", got)
+	}
+
+	outerExplicit := errcode.WrapRedacted(inner, errcode.UnavailableCode, "unavailable")
+	if got := errcode.FirstExplicitHTTPCode(outerExplicit); got != http.StatusServiceUnavailable {
+		t.Errorf("expected the outer explicit 503 to win but got %v", got)
+	}
+
+	noExplicit := errcode.WrapRedacted(errors.New("plain"), chainOuterCode, "plain")
+	if got := errcode.FirstExplicitHTTPCode(noExplicit); got != http.StatusBadRequest {
+		t.Errorf("expected the outer HTTP code but got %v", got)
+	}
+	if got := errcode.FirstExplicitHTTPCode(errors.New("plain")); got != http.StatusInternalServerError {
+		t.Errorf("expected 500 for an error without a code but got %v", got)
+	}
+}
+
+func TestMinimalJSON(t *testing.T) {
+	got := string(errcode.MinimalJSON(errcode.NewInternalErr(errors.New("internal"))))
+	if expected := `{"code":"internal","status":500}`; got != expected {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+	got = string(errcode.MinimalJSON(errcode.NewPaymentRequiredErr("trial_expired")))
+	if expected := `{"code":"auth.forbidden.payment","status":402}`; got != expected {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(got), &decoded); err != nil {
+		t.Errorf("expected valid JSON: %v", err)
+	}
+}
+
+func BenchmarkMinimalJSON(b *testing.B) {
+	err := errcode.NewNotFoundErr(errors.New("missing"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		errcode.MinimalJSON(err)
+	}
+}
+
+func BenchmarkJSONFormat(b *testing.B) {
+	err := errcode.NewNotFoundErr(errors.New("missing"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(errcode.NewJSONFormat(err)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWithOriginService(t *testing.T) {
+	if origin := errcode.OriginService(MinimalError{}); origin != "" {
+		t.Errorf("expected no origin but got %v", origin)
+	}
+	err := errcode.WithOriginService(errcode.NewNotFoundErr(fmt.Errorf("missing")), "users")
+	AssertCode(t, err, "missing")
+	ErrorEquals(t, err, "missing")
+	again := errcode.WithOriginService(err, "gateway")
+	if origin := errcode.OriginService(errors.Annotate(again, "annotated")); origin != "users" {
+		t.Errorf("expected the first origin to win but got %v", origin)
+	}
+	expected := map[string]interface{}{"data": map[string]interface{}{}, "origin": "users"}
+	jsonEquals(t, "ClientData", expected, errcode.ClientData(again))
+}
+
+// cyclicErr causes itself through another error
+type cyclicErr struct{ next error }
+
+func (e *cyclicErr) Error() string { return "cyclic" }
+func (e *cyclicErr) Cause() error  { return e.next }
+
+// joinedErr wraps multiple errors
+type joinedErr []error
+
+func (e joinedErr) Error() string   { return "joined" }
+func (e joinedErr) Unwrap() []error { return e }
+
+// mapErr wraps the errors of its values
+type mapErr map[string]error
+
+func (e mapErr) Error() string { return "map" }
+func (e mapErr) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// structErr is a value that cannot be identified, so only the depth limit stops a cycle through it
+type structErr struct{ errs []error }
+
+func (e structErr) Error() string   { return "struct" }
+func (e structErr) Unwrap() []error { return e.errs }
+
+func TestFindCode(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(errors.New("missing"))
+	wrapped := fmt.Errorf("std: %w", errors.Annotate(fmt.Errorf("inner: %w", notFound), "pingcap"))
+	found, ok := errcode.FindCode(wrapped)
+	if !ok || found != notFound {
+		t.Errorf("expected to find the NotFound error but got %v", found)
+	}
+
+	joined := joinedErr{errors.New("plain"), fmt.Errorf("wrapped: %w", notFound)}
+	if found, ok := errcode.FindCode(joined); !ok || found != notFound {
+		t.Errorf("expected to find the NotFound error in a joined error but got %v", found)
+	}
+
+	if _, ok := errcode.FindCode(fmt.Errorf("plain: %w", errors.New("plain"))); ok {
+		t.Errorf("expected no ErrorCode")
+	}
+	if _, ok := errcode.FindCode(nil); ok {
+		t.Errorf("expected no ErrorCode for nil")
+	}
+
+	cycle := &cyclicErr{}
+	cycle.next = fmt.Errorf("loop: %w", cycle)
+	if _, ok := errcode.FindCode(cycle); ok {
+		t.Errorf("expected no ErrorCode in a cycle")
+	}
+	selfJoined := joinedErr{errors.New("first"), nil}
+	selfJoined[1] = selfJoined
+	selfMapped := mapErr{}
+	selfMapped["self"] = selfMapped
+	selfStruct := structErr{errs: make([]error, 1)}
+	selfStruct.errs[0] = selfStruct
+	for _, err := range []error{selfJoined, selfMapped, selfStruct, fmt.Errorf("wrapped: %w", selfJoined)} {
+		if _, ok := errcode.FindCode(err); ok {
+			t.Errorf("expected no ErrorCode in a cycle through %T", err)
+		}
+	}
+	selfJoined = joinedErr{nil, notFound}
+	selfJoined[0] = selfJoined
+	if found, ok := errcode.FindCode(selfJoined); !ok || found != notFound {
+		t.Errorf("expected to find the NotFound error after a cycle but got %v", found)
+	}
+
+	aggregate := errcode.NewAggregateErrCode(errcode.InvalidInputCode, notFound)
+	for _, err := range []error{
+		errcode.WithOriginService(aggregate, "svc"),
+		fmt.Errorf("wrapped: %w", errcode.WithOriginService(aggregate, "svc")),
+	} {
+		if found, ok := errcode.FindCode(err); !ok || found.Code().CodeStr() != "input" {
+			t.Errorf("expected to find the aggregate error but got %v", found)
+		}
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(errors.New("missing"))
+	if coerced := errcode.Coerce(notFound); coerced != notFound {
+		t.Errorf("expected an ErrorCode to be unchanged but got %v", coerced)
+	}
+	coerced := errcode.Coerce(errors.Annotate(notFound, "annotated"))
+	AssertCode(t, coerced, "missing")
+	ErrorEquals(t, coerced, "annotated: missing")
+
+	coerced = errcode.Coerce(errors.New("plain"))
+	AssertCode(t, coerced, "internal")
+	if errcode.StackTrace(coerced) == nil {
+		t.Errorf("expected a stack trace for a plain error")
+	}
+	if errcode.Coerce(nil) != nil {
+		t.Errorf("expected nil for a nil error")
+	}
+
+	coerced = errcode.Coerce(fmt.Errorf("ctx: %w", notFound))
+	AssertCode(t, coerced, "missing")
+	aggregate := errcode.NewAggregateErrCode(errcode.InvalidInputCode, notFound)
+	AssertCode(t, errcode.Coerce(aggregate), "input")
+}
+
+// localizedErr only has a message in German
+type localizedErr struct{ MinimalError }
+
+func (e localizedErr) LocalizedMsg(lang language.Tag) string {
+	if lang == language.German {
+		return "nicht gefunden"
+	}
+	return ""
+}
+
+func TestLocalizedUserMsg(t *testing.T) {
+	catalog := errcode.NewMsgCatalog().
+		Add(errcode.NotFoundCode, language.French, "introuvable").
+		Add(errcode.NotFoundCode, language.MustParse("fr-BE"), "pas trouvé")
+	defer errcode.SetMsgCatalog(errcode.GetMsgCatalog())
+	errcode.SetMsgCatalog(catalog)
+
+	err := errcode.NewNotFoundErr(errors.New("missing"))
+	AssertLocalizedUserMsg(t, err, "fr-BE", "pas trouvé")
+	AssertLocalizedUserMsg(t, err, "fr-CA", "introuvable")
+	AssertLocalizedUserMsg(t, err, "fr", "introuvable")
+	AssertLocalizedUserMsg(t, err, "en-US", "missing")
+	AssertLocalizedUserMsg(t, errcode.WithUserMsg(err, "not found"), "ja", "not found")
+
+	AssertLocalizedUserMsg(t, localizedErr{}, "de-AT", "nicht gefunden")
+	AssertLocalizedUserMsg(t, localizedErr{}, "fr-CA", "error")
+}
+
+func AssertLocalizedUserMsg(t *testing.T, errCode errcode.ErrorCode, lang string, expected string) {
+	t.Helper()
+	if got := errcode.LocalizedUserMsg(errCode, language.MustParse(lang)); got != expected {
+		t.Errorf("expected message %q for %v but got %q", expected, lang, got)
+	}
+}
+
+type userNotFound struct {
+	ID     int    `json:"id"`
+	Tenant string `json:"tenant,omitempty"`
+	Region string
+}
+
+func (e userNotFound) Error() string      { return fmt.Sprintf("user %d", e.ID) }
+func (e userNotFound) Code() errcode.Code { return userNotFoundCode }
+
+var userNotFoundCode = errcode.NotFoundCode.Child("missing.user").SetMsgTemplate("user {id} not found in {tenant}/{Region}")
+var templateMapCode = errcode.NotFoundCode.Child("missing.templatemap").SetMsgTemplate("{kind} {name} not found")
+
+type mapNotFound map[string]string
+
+func (e mapNotFound) Error() string      { return "map not found" }
+func (e mapNotFound) Code() errcode.Code { return templateMapCode }
+
+func TestRenderMsg(t *testing.T) {
+	err := userNotFound{ID: 7, Tenant: "acme", Region: "eu"}
+	msg, renderErr := errcode.RenderMsg(err)
+	if renderErr != nil || msg != "user 7 not found in acme/eu" {
+		t.Errorf("unexpected rendering %q %v", msg, renderErr)
+	}
+	jsonEquals(t, "UserMsg", "user 7 not found in acme/eu", errcode.NewJSONFormat(err).Msg)
+
+	msg, renderErr = errcode.RenderMsg(mapNotFound{"kind": "table"})
+	if renderErr == nil || msg != "table {name} not found" {
+		t.Errorf("expected a missing parameter to be left in place with an error, got %q %v", msg, renderErr)
+	}
+	ErrorEquals(t, mapNotFound{"kind": "table"}, "map not found")
+	if got := errcode.UserMsg(mapNotFound{"kind": "table"}); got != "map not found" {
+		t.Errorf("expected UserMsg to fall back to Error() for a missing parameter, got %q", got)
+	}
+
+	if msg, renderErr := errcode.RenderMsg(MinimalError{}); msg != "" || renderErr != nil {
+		t.Errorf("expected no rendering without a template, got %q %v", msg, renderErr)
+	}
+	if errcode.NotFoundCode.MsgTemplate() != "" {
+		t.Errorf("expected templates to not be inherited")
+	}
+}
+
+func TestDescription(t *testing.T) {
+	if got := exampleCode.Description(); got != "An example was not found." {
+		t.Errorf("unexpected description %q", got)
+	}
+	if got := errcode.NotFoundCode.Description(); got != "" {
+		t.Errorf("expected descriptions to not be inherited, got %q", got)
+	}
+	AssertIncludesCodes(t, errcode.DescribedCodes(), exampleCode)
+	for _, code := range errcode.DescribedCodes() {
+		if code.Description() == "" {
+			t.Errorf("expected %v to have a description", code.CodeStr())
+		}
+	}
+
+	entries := make(map[errcode.CodeStr]errcode.CatalogEntry)
+	for _, entry := range errcode.GenerateCatalog() {
+		entries[entry.Code] = entry
+	}
+	for _, code := range []errcode.Code{errcode.InternalCode, errcode.NotFoundCode, errcode.StateCode, errcode.InvalidInputCode, errcode.AuthCode} {
+		if _, ok := entries[code.CodeStr()]; !ok {
+			t.Errorf("expected the catalog to list %v", code.CodeStr())
+		}
+	}
+	if entry := entries[exampleCode.CodeStr()]; entry.Description != "An example was not found." {
+		t.Errorf("expected the catalog to give the description but got %q", entry.Description)
+	}
+	if entry := entries[errcode.NotFoundCode.CodeStr()]; entry.Description != "" {
+		t.Errorf("expected no description for NotFoundCode but got %q", entry.Description)
+	}
+}
+
+var (
+	validateRootCode = errcode.NewCode("validateroot").SetHTTP(http.StatusConflict)
+	validateLeafCode = validateRootCode.Child("validateroot.leaf")
+)
+
+func TestValidateCodes(t *testing.T) {
+	AssertValidateErrors(t, errcode.ValidateCodes(), validateLeafCode, false)
+
+	validateRootCode.ResetHTTP()
+	defer validateRootCode.SetHTTP(http.StatusConflict)
+	errs := errcode.ValidateCodes()
+	AssertValidateErrors(t, errs, validateLeafCode, true)
+	// only leaves are validated
+	AssertValidateErrors(t, errs, validateRootCode, false)
+
+	registry := errcode.NewRegistry()
+	if err := registry.Register(validateRootCode); err != nil {
+		t.Fatal(err)
+	}
+	// the root is a leaf of a registry without its children
+	AssertValidateErrors(t, registry.Validate(), validateRootCode, true)
+	alwaysFails := func(code errcode.Code) error { return fmt.Errorf("failed %v", code.CodeStr()) }
+	if errs := registry.Validate(alwaysFails); len(errs) != 1 || errs[0].Error() != "failed validateroot" {
+		t.Errorf("expected the given rule to be used, got %v", errs)
+	}
+}
+
+func AssertValidateErrors(t *testing.T, errs []error, code errcode.Code, expected bool) {
+	t.Helper()
+	found := false
+	for _, err := range errs {
+		if strings.Contains(err.Error(), "code "+code.CodeStr().String()+" ") {
+			found = true
+		}
+	}
+	if found != expected {
+		t.Errorf("expected an error for %v to be %v in %v", code.CodeStr(), expected, errs)
+	}
+}
+
+var builtCode = errcode.NewCodeBuilder("state.built").
+	Parent(errcode.StateCode).
+	HTTP(http.StatusConflict).
+	Retryable(true).
+	Description("built with a CodeBuilder").
+	Build()
+
+func TestCodeBuilder(t *testing.T) {
+	if builtCode.CodeStr() != "state.built" || !builtCode.IsAncestor(errcode.StateCode) {
+		t.Errorf("unexpected code %v", builtCode.CodeStr())
+	}
+	if builtCode.HTTPCode() != http.StatusConflict || !builtCode.IsRetryable() || builtCode.Description() != "built with a CodeBuilder" {
+		t.Errorf("unexpected meta data for %v", builtCode.CodeStr())
+	}
+	if code, ok := errcode.LookupCode("state.built"); !ok || !code.Equal(builtCode) {
+		t.Errorf("expected the code to be registered")
+	}
+
+	builder := errcode.NewCodeBuilder("state.unbuilt").
+		Parent(errcode.StateCode).
+		HTTP(http.StatusConflict).
+		Description("never registered").
+		HTTP(http.StatusGone)
+	if _, err := builder.TryBuild(); err == nil {
+		t.Errorf("expected an error for setting HTTP twice")
+	}
+	AssertPanics(t, "Build", func() { builder.Build() })
+	if _, ok := errcode.LookupCode("state.unbuilt"); ok {
+		t.Errorf("expected a failed build not to register the code")
+	}
+
+	if _, err := errcode.NewCodeBuilder("state.built").Parent(errcode.StateCode).TryBuild(); err == nil {
+		t.Errorf("expected an error for an already registered code")
+	}
+	if _, err := errcode.NewCodeBuilder("other.built").Parent(errcode.StateCode).TryBuild(); err == nil {
+		t.Errorf("expected an error for an incorrect parent path")
+	}
+}
+
+func TestNewCodedErrorf(t *testing.T) {
+	err := errcode.NewCodedErrorf(errcode.InvalidInputCode, "field %v must be at most %d", "name", 10)
+	if err.Error() != "field name must be at most 10" {
+		t.Errorf("unexpected message %v", err.Error())
+	}
+	if !err.Code().Equal(errcode.InvalidInputCode) {
+		t.Errorf("expected InvalidInputCode but got %v", err.Code().CodeStr())
+	}
+
+	// an ErrorCode in the args does not replace the given code
+	wrapped := errcode.NewCodedErrorf(errcode.InvalidInputCode, "lookup: %w", errcode.NewNotFoundErr(fmt.Errorf("missing")))
+	if !wrapped.Code().Equal(errcode.InvalidInputCode) {
+		t.Errorf("expected InvalidInputCode but got %v", wrapped.Code().CodeStr())
+	}
+	if wrapped.Error() != "lookup: missing" {
+		t.Errorf("unexpected message %v", wrapped.Error())
+	}
+}
+
+var invalidEmailCode = errcode.InvalidInputCode.Child("input.email")
+
+func TestWithCode(t *testing.T) {
+	original := errcode.NewInvalidInputErr(fmt.Errorf("bad email"))
+	recoded := errcode.WithCode(original, invalidEmailCode)
+	AssertCode(t, recoded, invalidEmailCode.CodeStr())
+	AssertHTTPCode(t, recoded, http.StatusBadRequest)
+	ErrorEquals(t, recoded, "bad email")
+	if errcode.ClientData(recoded) != errcode.ClientData(original) {
+		t.Errorf("expected the client data of the original error")
+	}
+	if errors.Cause(recoded) != errors.Cause(original) {
+		t.Errorf("expected the cause of the original error")
+	}
+
+	AssertPanics(t, "WithCode", func() { errcode.WithCode(original, errcode.NotFoundCode) })
+	// a code cannot be made broader
+	AssertPanics(t, "WithCode", func() { errcode.WithCode(recoded, errcode.InvalidInputCode) })
+}
+
+func TestIsAncestorSemantics(t *testing.T) {
+	for _, test := range []struct {
+		code, ancestor errcode.Code
+		expected       bool
+	}{
+		// a code is its own ancestor, whether or not it has a Parent
+		{errcode.InternalCode, errcode.InternalCode, true},
+		{errcode.UnimplementedCode, errcode.UnimplementedCode, true},
+		// a root code has no other ancestors
+		{errcode.InternalCode, errcode.UnimplementedCode, false},
+		{errcode.UnimplementedCode, errcode.InternalCode, true},
+		{errcode.NotFoundCode, errcode.InternalCode, false},
+		{invalidEmailCode, errcode.InvalidInputCode, true},
+		{invalidEmailCode, errcode.StateCode, false},
+	} {
+		if got := test.code.IsAncestor(test.ancestor); got != test.expected {
+			t.Errorf("expected %v.IsAncestor(%v) to be %v", test.code.CodeStr(), test.ancestor.CodeStr(), test.expected)
+		}
+		if got := test.ancestor.IsDescendant(test.code); got != test.expected {
+			t.Errorf("expected %v.IsDescendant(%v) to be %v", test.ancestor.CodeStr(), test.code.CodeStr(), test.expected)
+		}
+	}
+
+	// the code of an internal error is kept, but other codes become InternalCode
+	AssertCode(t, errcode.NewInternalErr(errcode.NewUnimplementedErr(fmt.Errorf("todo"))), errcode.UnimplementedCode.CodeStr())
+	AssertCode(t, errcode.NewInternalErr(errcode.NewNotFoundErr(fmt.Errorf("missing"))), errcode.InternalCode.CodeStr())
+}
+
+// nestedCode is nested 10 levels below nestedRootCode, which has an HTTP code
+var nestedRootCode = errcode.NewCode("nested").SetHTTP(http.StatusNotFound)
+var nestedCode = func() errcode.Code {
+	code := nestedRootCode
+	for i := 0; i < 10; i++ {
+		code = code.Child(code.CodeStr() + errcode.CodeStr(fmt.Sprintf(".level%d", i)))
+	}
+	return code
+}()
+
+func TestHTTPCodeCache(t *testing.T) {
+	if nestedCode.HTTPCode() != http.StatusNotFound {
+		t.Errorf("expected the HTTP code of the root but got %v", nestedCode.HTTPCode())
+	}
+	// changing an ancestor invalidates the cached HTTP code of its descendants
+	parent := *nestedCode.Parent
+	parent.ForceSetHTTP(http.StatusGone)
+	if nestedCode.HTTPCode() != http.StatusGone {
+		t.Errorf("expected the changed HTTP code but got %v", nestedCode.HTTPCode())
+	}
+	parent.ResetHTTP()
+	if nestedCode.HTTPCode() != http.StatusNotFound {
+		t.Errorf("expected the HTTP code of the root but got %v", nestedCode.HTTPCode())
+	}
+}
+
+func BenchmarkHTTPCode(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		nestedCode.HTTPCode()
+	}
+}
+
+// BenchmarkHTTPCodeUncached walks the ancestors the same as HTTPCode did before it was cached
+func BenchmarkHTTPCodeUncached(b *testing.B) {
+	httpMetaData := errcode.MetaData{}
+	nestedRootCode.SetMetaDataOverride(httpMetaData, http.StatusNotFound)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nestedCode.MetaDataFromAncestors(httpMetaData)
+	}
+}
+
+func TestSetStackCaptureDepth(t *testing.T) {
+	full := errcode.StackTrace(errcode.NewInternalErr(fmt.Errorf("full")))
+	if len(full) <= 2 {
+		t.Fatalf("expected the full stack but got %v", full)
+	}
+
+	errcode.SetStackCaptureDepth(2)
+	defer errcode.SetStackCaptureDepth(0)
+	limited := errcode.StackTrace(errcode.NewInternalErr(fmt.Errorf("limited")))
+	if len(limited) != 2 {
+		t.Errorf("expected 2 frames but got %v", len(limited))
+	}
+	if frame := fmt.Sprintf("%n", limited[0]); frame != "TestSetStackCaptureDepth" {
+		t.Errorf("expected the stack to start at the caller but got %v", frame)
+	}
+	if frame := fmt.Sprintf("%n", errcode.StackTrace(errcode.NewStackCode(errcode.NewNotFoundErr(fmt.Errorf("missing"))))[0]); frame != "TestSetStackCaptureDepth" {
+		t.Errorf("expected the stack to start at the caller but got %v", frame)
+	}
+}
+
+func BenchmarkNewInternalErr(b *testing.B) {
+	err := fmt.Errorf("flapping")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		errcode.NewInternalErr(err)
+	}
+}
+
+func BenchmarkNewInternalErrDepth4(b *testing.B) {
+	errcode.SetStackCaptureDepth(4)
+	defer errcode.SetStackCaptureDepth(0)
+	err := fmt.Errorf("flapping")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		errcode.NewInternalErr(err)
+	}
+}
+
+func returnNotFound() error { return errcode.ErrNotFound }
+
+func TestSentinels(t *testing.T) {
+	first, second := returnNotFound(), returnNotFound()
+	if first != second || !goerrors.Is(first, errcode.ErrNotFound) {
+		t.Errorf("expected two returns of a sentinel to be equal")
+	}
+	AssertCode(t, errcode.ErrNotFound, errcode.NotFoundCode.CodeStr())
+	AssertHTTPCode(t, errcode.ErrNotFound, http.StatusNotFound)
+	ErrorEquals(t, errcode.ErrNotFound, "not found")
+	AssertCode(t, errcode.ErrForbidden, errcode.ForbiddenCode.CodeStr())
+	AssertHTTPCode(t, errcode.ErrForbidden, http.StatusForbidden)
+	if first == errcode.ErrForbidden || goerrors.Is(first, errcode.ErrForbidden) {
+		t.Errorf("expected sentinels of different codes to differ")
+	}
+
+	// errors with the same code match the sentinel
+	if !goerrors.Is(errcode.NewNotFoundErr(fmt.Errorf("no user")), errcode.ErrNotFound) {
+		t.Errorf("expected a not found error to match ErrNotFound")
+	}
+	if !goerrors.Is(fmt.Errorf("lookup: %w", errcode.NewInternalErr(fmt.Errorf("oops"))), errcode.ErrInternal) {
+		t.Errorf("expected a wrapped internal error to match ErrInternal")
+	}
+	if goerrors.Is(errcode.NewNotFoundErr(fmt.Errorf("no user")), errcode.ErrInternal) {
+		t.Errorf("expected a not found error not to match ErrInternal")
+	}
+
+	// through every wrapper
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("no user"))
+	for _, inner := range []errcode.ErrorCode{errcode.ErrNotFound, notFound} {
+		for name, wrapped := range map[string]error{
+			"WithUserMsg":       errcode.WithUserMsg(inner, "not here"),
+			"WithOriginService": errcode.WithOriginService(inner, "users"),
+			"WithCode":          errcode.WithCode(inner, errcode.NotFoundCode),
+			"WithMaxRetries":    errcode.WithMaxRetries(inner, 3),
+			"WithRemediation":   errcode.WithRemediation(inner, []string{"create it"}),
+			"WithForceSample":   errcode.WithForceSample(inner),
+			"WithOperation":     errcode.WithOperation(inner, "lookup"),
+			"NewStackCode":      errcode.NewStackCode(inner),
+			"WrapRedacted":      errcode.WrapRedacted(inner, errcode.InternalCode, "hidden"),
+			"DuplicateErrCode":  errcode.DuplicateErrCode{Err: inner, Count: 2},
+			"fmt.Errorf":        fmt.Errorf("lookup: %w", errcode.WithUserMsg(inner, "not here")),
+		} {
+			if !goerrors.Is(wrapped, errcode.ErrNotFound) {
+				t.Errorf("expected %v of %v to match ErrNotFound", name, inner)
+			}
+		}
+	}
+	if !goerrors.Is(errcode.WrapRedacted(notFound, errcode.InternalCode, "hidden"), errcode.ErrInternal) {
+		t.Errorf("expected a redacted error to match the sentinel of its new code")
+	}
+}
+
+func TestWrapperFormat(t *testing.T) {
+	internal := errcode.NewInternalErr(fmt.Errorf("oops"))
+	wrapped := errcode.WithUserMsg(errcode.WithOriginService(internal, "users"), "try again")
+	if formatted := fmt.Sprintf("%+v", wrapped); formatted != fmt.Sprintf("%+v", internal) || !strings.Contains(formatted, "TestWrapperFormat") {
+		t.Errorf("expected the stack trace through the wrappers but got %v", formatted)
+	}
+	if formatted := fmt.Sprintf("%v %s %q", wrapped, wrapped, wrapped); formatted != `oops oops "oops"` {
+		t.Errorf("unexpected format %v", formatted)
+	}
+}
+
+func TestCodeErr(t *testing.T) {
+	err := errcode.NotFoundCode.Err()
+	if !err.Code().Equal(errcode.NotFoundCode) {
+		t.Errorf("expected NotFoundCode but got %v", err.Code().CodeStr())
+	}
+	ErrorEquals(t, err, "missing")
+	ErrorEquals(t, exampleCode.Err(), exampleCode.Description())
+
+	err = errcode.NotFoundCode.Errorf("user %d", 42)
+	AssertCode(t, err, errcode.NotFoundCode.CodeStr())
+	ErrorEquals(t, err, "user 42")
+}
+
+func TestFullClientData(t *testing.T) {
+	full := errcode.FullClientData(errcode.NewPaymentRequiredErr("trial_expired"))
+	expected := map[string]interface{}{
+		"code":   errcode.PaymentRequiredCode.CodeStr(),
+		"msg":    errcode.NewPaymentRequiredErr("trial_expired").Error(),
+		"reason": "trial_expired",
+	}
+	if !reflect.DeepEqual(full, expected) {
+		t.Errorf("expected %#v but got %#v", expected, full)
+	}
+
+	full = errcode.FullClientData(errcode.ErrNotFound)
+	expected = map[string]interface{}{"code": errcode.NotFoundCode.CodeStr(), "msg": "not found", "data": nil}
+	if !reflect.DeepEqual(full, expected) {
+		t.Errorf("expected %#v but got %#v", expected, full)
+	}
+}
+
+var defaultHTTPCode = errcode.NewCode("httpdefault")
+
+func TestIsClientServerError(t *testing.T) {
+	serverErr := map[errcode.Code]bool{
+		errcode.InternalCode:     true,
+		errcode.UnavailableCode:  true,
+		errcode.InvalidInputCode: false,
+		errcode.NotFoundCode:     false,
+		defaultHTTPCode:          false,
+	}
+	for code, expected := range serverErr {
+		if got := code.IsServerError(); got != expected {
+			t.Errorf("expected IsServerError of %v to be %v", code.CodeStr(), expected)
+		}
+		if got := code.IsClientError(); got == expected {
+			t.Errorf("expected IsClientError of %v to be %v", code.CodeStr(), !expected)
+		}
+	}
+
+	if !errcode.IsServerError(errcode.NewInternalErr(errors.New("boom"))) {
+		t.Errorf("expected an internal error to be a server error")
+	}
+	if !errcode.IsClientError(errcode.NewInvalidInputErr(errors.New("bad"))) {
+		t.Errorf("expected an invalid input error to be a client error")
+	}
+}
+
+var (
+	redactedParentCode = errcode.NewCode("secret").SetRedacted(true)
+	redactedChildCode  = redactedParentCode.Child("secret.key")
+
+	redactedTemplateCode = errcode.NewCode("vault").SetRedacted(true).SetMsgTemplate("token {Token} rejected")
+)
+
+func TestSetRedacted(t *testing.T) {
+	if !redactedChildCode.IsRedacted() || errcode.NotFoundCode.IsRedacted() {
+		t.Errorf("expected only the redacted family to be redacted")
+	}
+
+	err := errcode.NewCodedError(Struct1{A: "sensitive"}, redactedChildCode)
+	jsonEquals(t, "ClientData", Struct1{A: "sensitive"}, errcode.ClientData(err))
+	placeholder := errcode.RedactedClientData{Code: "secret.key", Msg: "Bad Request", Redacted: true}
+	jsonEquals(t, "JSONFormat", placeholder, errcode.NewJSONFormat(err).Data)
+	if full := errcode.FullClientData(err); full["A"] != nil || full["redacted"] != true {
+		t.Errorf("expected redacted full client data but got %v", full)
+	}
+
+	// The message does not leak the client data or the Error() of the cause either
+	for _, err := range []errcode.ErrorCode{
+		errcode.NewCodedError(vaultTokenErr{Token: "s3cr3t"}, redactedTemplateCode),
+		errcode.NewCodedError(errors.New("password=hunter2"), redactedChildCode),
+	} {
+		if msg := errcode.UserMsg(err); msg != "Bad Request" {
+			t.Errorf("expected the status text as the user message but got %v", msg)
+		}
+		rec := httptest.NewRecorder()
+		if writeErr := errcodehttp.WriteError(rec, err); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		problem := httptest.NewRecorder()
+		if writeErr := errcodehttp.WriteProblemJSON(problem, err); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		for _, body := range []string{rec.Body.String(), problem.Body.String()} {
+			if strings.Contains(body, "s3cr3t") || strings.Contains(body, "hunter2") {
+				t.Errorf("expected a redacted body but got %v", body)
+			}
+		}
+	}
+	safe := errcode.WithUserMsg(errcode.NewCodedError(vaultTokenErr{Token: "s3cr3t"}, redactedTemplateCode), "token rejected")
+	if msg := errcode.UserMsg(safe); msg != "token rejected" {
+		t.Errorf("expected an explicit user message to be kept but got %v", msg)
+	}
+}
+
+type vaultTokenErr struct{ Token string }
+
+func (e vaultTokenErr) Error() string { return "token " + e.Token }
+
+var (
+	semanticInputCode    = errcode.InvalidInputCode.Child("input.semantic").SetHTTPUnprocessable()
+	semanticLeafCode     = semanticInputCode.Child("input.semantic.date")
+	semanticConflict     = semanticInputCode.Child("input.semantic.conflict").SetHTTP(http.StatusConflict)
+	semanticConflictLeaf = semanticConflict.Child("input.semantic.conflict.leaf")
+	semanticBothCode     = errcode.InvalidInputCode.Child("input.semantic2").SetHTTP(http.StatusBadRequest).SetHTTPUnprocessable()
+)
+
+func TestHTTPCodeUnprocessable(t *testing.T) {
+	for code, expected := range map[errcode.Code]int{
+		semanticInputCode:        422,
+		semanticLeafCode:         422,
+		semanticConflict:         409,
+		semanticConflictLeaf:     409,
+		semanticBothCode:         422,
+		errcode.InvalidInputCode: 400,
+		errcode.NotFoundCode:     404,
+	} {
+		if got := code.HTTPCodeUnprocessable(); got != expected {
+			t.Errorf("expected HTTPCodeUnprocessable of %v to be %v but got %v", code.CodeStr(), expected, got)
+		}
+	}
+	if got := semanticLeafCode.HTTPCode(); got != 400 {
+		t.Errorf("expected the primary HTTPCode to stay 400 but got %v", got)
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	ancestors := errcode.AlreadyExistsCode.Ancestors()
+	expected := []errcode.Code{errcode.AlreadyExistsCode, errcode.StateCode}
+	if len(ancestors) != len(expected) {
+		t.Fatalf("expected ancestors %v but got %v", expected, ancestors)
+	}
+	for i, code := range expected {
+		if !ancestors[i].Equal(code) {
+			t.Errorf("expected ancestor %v to be %v but got %v", i, code.CodeStr(), ancestors[i].CodeStr())
+		}
+	}
+	if got := errcode.StateCode.Ancestors(); len(got) != 1 || !got[0].Equal(errcode.StateCode) {
+		t.Errorf("expected a root code to be its only ancestor but got %v", got)
+	}
+
+	if root := errcode.AlreadyExistsCode.Root(); !root.Equal(errcode.StateCode) {
+		t.Errorf("expected root StateCode but got %v", root.CodeStr())
+	}
+	if root := errcode.IdempotencyConflictCode.Root(); !root.Equal(errcode.StateCode) {
+		t.Errorf("expected root StateCode but got %v", root.CodeStr())
+	}
+	if root := errcode.StateCode.Root(); !root.Equal(errcode.StateCode) {
+		t.Errorf("expected StateCode to be its own root but got %v", root.CodeStr())
+	}
+}
+
+func TestInFamily(t *testing.T) {
+	for code, expected := range map[errcode.Code]bool{
+		errcode.AuthCode:             true,
+		errcode.NotAuthenticatedCode: true,
+		errcode.ForbiddenCode:        true,
+		errcode.PaymentRequiredCode:  true,
+		errcode.NotFoundCode:         false,
+		errcode.StateCode:            false,
+	} {
+		if got := code.InFamily(errcode.AuthCode); got != expected {
+			t.Errorf("expected %v InFamily of AuthCode to be %v", code.CodeStr(), expected)
+		}
+	}
+	if !errcode.InFamily(errcode.NewForbiddenErr(errors.New("denied")), errcode.AuthCode) {
+		t.Error("expected a forbidden error to be in the auth family")
+	}
+	if !errcode.InFamily(errcode.NewNotAuthenticatedErr(errors.New("who")), errcode.AuthCode) {
+		t.Error("expected a not authenticated error to be in the auth family")
+	}
+	if errcode.InFamily(errcode.NewNotFoundErr(errors.New("missing")), errcode.AuthCode) {
+		t.Error("expected a not found error to not be in the auth family")
+	}
+	if errcode.InFamily(nil, errcode.AuthCode) {
+		t.Error("expected nil to not be in the auth family")
+	}
+}
+
+func TestNotAuthenticatedChildren(t *testing.T) {
+	expired := errcode.NewTokenExpiredErr(errors.New("token expired"))
+	AssertCode(t, expired, "auth.unauthenticated.expired")
+	AssertHTTPCode(t, expired, 401)
+	invalid := errcode.NewInvalidCredentialsErr(errors.New("bad signature"))
+	AssertCode(t, invalid, "auth.unauthenticated.invalid")
+	AssertHTTPCode(t, invalid, 401)
+	for _, errCode := range []errcode.ErrorCode{expired, invalid} {
+		if !errcode.InFamily(errCode, errcode.NotAuthenticatedCode) {
+			t.Errorf("expected %v to be a NotAuthenticatedCode", errCode.Code().CodeStr())
+		}
+	}
+}
+
+func TestNewForbiddenErrScope(t *testing.T) {
+	forbiddenCodeStr := errcode.ForbiddenCode.CodeStr()
+	err := errcode.NewForbiddenErrScope(errors.New("cannot delete"), "repo:delete")
+	AssertCode(t, err, forbiddenCodeStr)
+	AssertHTTPCode(t, err, 403)
+	ErrorEquals(t, err, "cannot delete")
+	ClientDataEquals(t, err, map[string]string{"requiredScope": "repo:delete"}, forbiddenCodeStr)
+
+	noScope := errcode.NewForbiddenErrScope(errors.New("cannot delete"), "")
+	ClientDataEquals(t, noScope, map[string]string{}, forbiddenCodeStr)
+}
+
+var (
+	noHTTPCode      = errcode.NewCode("nohttp").SetNoHTTP()
+	noHTTPChildCode = noHTTPCode.Child("nohttp.child")
+	noHTTPMapped    = noHTTPCode.Child("nohttp.mapped").SetHTTP(http.StatusConflict)
+)
+
+func TestSetNoHTTP(t *testing.T) {
+	for code, expected := range map[errcode.Code]int{
+		noHTTPCode:       errcode.NoHTTPCode,
+		noHTTPChildCode:  errcode.NoHTTPCode,
+		noHTTPMapped:     http.StatusConflict,
+		defaultHTTPCode:  http.StatusBadRequest,
+		errcode.AuthCode: http.StatusBadRequest,
+	} {
+		if got := code.HTTPCode(); got != expected {
+			t.Errorf("expected HTTPCode of %v to be %v but got %v", code.CodeStr(), expected, got)
+		}
+	}
+
+	noHTTP := noHTTPCode.Err()
+	if status := noHTTPCode.HTTPStatus(); status != http.StatusInternalServerError {
+		t.Errorf("expected HTTPStatus 500 but got %v", status)
+	}
+	notFound := errcode.NewNotFoundErr(errors.New("missing"))
+	AssertCombineHTTP(t, http.StatusInternalServerError, noHTTP)
+	AssertCombineHTTP(t, http.StatusInternalServerError, noHTTP, notFound)
+	AssertCombineHTTP(t, http.StatusInternalServerError, notFound, noHTTP)
+	if minimal := string(errcode.MinimalJSON(noHTTP)); minimal != `{"code":"nohttp","status":500}` {
+		t.Errorf("expected MinimalJSON with status 500 but got %v", minimal)
+	}
+	if !noHTTPCode.IsServerError() || noHTTPCode.IsClientError() {
+		t.Error("expected a code without HTTP to be a server error")
+	}
+	if !noHTTPCode.IsSLAImpacting() {
+		t.Error("expected a code without HTTP to be SLA impacting")
+	}
+	if severity := noHTTPCode.Severity(); severity != errcode.SeverityError {
+		t.Errorf("expected SeverityError but got %v", severity)
+	}
+	if fields := errcode.ToFields(noHTTP); fields["http_status"] != http.StatusInternalServerError {
+		t.Errorf("expected http_status 500 but got %v", fields["http_status"])
+	}
+	if err := errcode.RequireHTTP(noHTTPChildCode); err != nil {
+		t.Errorf("expected RequireHTTP to accept a code without HTTP but got %v", err)
+	}
+	if err := errcode.RequireHTTP(defaultHTTPCode); err == nil {
+		t.Error("expected RequireHTTP to reject a code without an HTTP code")
+	}
+}
+
+var (
+	billingNamespace = errcode.NewNamespace("billing")
+	usersNamespace   = errcode.NewNamespace("users")
+	billingInternal  = billingNamespace.NewCode("internal").SetHTTP(http.StatusInternalServerError)
+	usersInternal    = usersNamespace.NewCode("internal")
+	billingDB        = billingInternal.Child("billing.internal.db")
+	billingFamilyDB  = billingNamespace.Child(errcode.InternalCode, "db")
+	billingCache     = billingNamespace.Child(errcode.InternalCode, "cache")
+	billingInvoice   = billingNamespace.Child(errcode.NotFoundCode, "invoice")
+)
+
+func TestNamespace(t *testing.T) {
+	if billingInternal.CodeStr() != "billing.internal" || usersInternal.CodeStr() != "users.internal" {
+		t.Fatalf("expected prefixed codes but got %v and %v", billingInternal.CodeStr(), usersInternal.CodeStr())
+	}
+	if billingInternal.Equal(usersInternal) || billingInternal.Equal(errcode.InternalCode) {
+		t.Error("expected codes in different namespaces to be different")
+	}
+
+	for _, expected := range []errcode.Code{billingInternal, usersInternal, billingDB} {
+		if code, ok := errcode.LookupCode(expected.CodeStr()); !ok || !code.Equal(expected) {
+			t.Errorf("expected %v to be registered", expected.CodeStr())
+		}
+	}
+	if code, ok := billingNamespace.Lookup("internal"); !ok || !code.Equal(billingInternal) {
+		t.Errorf("expected to find internal in the billing namespace but got %v", code.CodeStr())
+	}
+	if code, ok := usersNamespace.Lookup("internal"); !ok || !code.Equal(usersInternal) {
+		t.Errorf("expected to find internal in the users namespace but got %v", code.CodeStr())
+	}
+	if code, ok := billingNamespace.Lookup("internal.db"); !ok || !code.Equal(billingDB) {
+		t.Errorf("expected to find internal.db in the billing namespace but got %v", code.CodeStr())
+	}
+	if _, ok := usersNamespace.Lookup("internal.db"); ok {
+		t.Error("expected internal.db to not be found in the users namespace")
+	}
+
+	if !billingDB.IsAncestor(billingInternal) || !billingDB.InFamily(billingNamespace.Code()) {
+		t.Error("expected billing.internal.db to be in the billing namespace")
+	}
+	if billingDB.IsAncestor(usersInternal) || usersInternal.InFamily(billingNamespace.Code()) {
+		t.Error("expected namespaces to not be ancestors of each other")
+	}
+	if billingDB.HTTPCode() != http.StatusInternalServerError || usersInternal.HTTPCode() != http.StatusBadRequest {
+		t.Error("expected meta data to be kept per namespace")
+	}
+	if codes := usersNamespace.Codes(); len(codes) != 1 || !codes[0].Equal(usersInternal) {
+		t.Errorf("expected only users.internal in the users namespace but got %v", codes)
+	}
+
+	// A code created with NewCode does not inherit from the standard codes
+	if usersInternal.IsRetryable() || usersInternal.Category() != errcode.CategoryUnknown {
+		t.Error("expected users.internal to have none of the meta data of InternalCode")
+	}
+	// but a code created with Child does
+	if billingFamilyDB.CodeStr() != "internal.billing.db" || billingInvoice.CodeStr() != "missing.billing.invoice" {
+		t.Errorf("expected codes under their family but got %v and %v", billingFamilyDB.CodeStr(), billingInvoice.CodeStr())
+	}
+	if !billingCache.Parent.Equal(*billingFamilyDB.Parent) || !billingFamilyDB.IsAncestor(errcode.InternalCode) {
+		t.Error("expected codes of the same family to share the family code of the namespace")
+	}
+	if billingFamilyDB.HTTPCode() != http.StatusInternalServerError || !billingFamilyDB.IsRetryable() || billingFamilyDB.Category() != errcode.CategoryInternal {
+		t.Error("expected internal.billing.db to inherit the meta data of InternalCode")
+	}
+	if billingInvoice.HTTPCode() != http.StatusNotFound || billingInvoice.Category() != errcode.CategoryNotFound {
+		t.Error("expected missing.billing.invoice to inherit the meta data of NotFoundCode")
+	}
+
+	AssertPanics(t, "a duplicate namespaced code", func() { billingNamespace.NewCode("internal") })
+	AssertPanics(t, "a duplicate namespaced family code", func() { billingNamespace.Child(errcode.InternalCode, "db") })
+	AssertPanics(t, "a duplicate namespace", func() { errcode.NewNamespace("billing") })
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// ValidationError reports why the value of one input field is invalid.
+// It is an ErrorCode with InvalidInputCode so it can be returned by itself or combined with Combine.
+// Use NewInvalidInputErrFields to report multiple fields in one error.
+type ValidationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// Code returns InvalidInputCode
+func (e ValidationError) Code() Code {
+	return InvalidInputCode
+}
+
+var _ ErrorCode = (*ValidationError)(nil) // assert implements interface
+
+// HasFieldErrors is an interface to retrieve the invalid fields of an error.
+// Generally the fields should be retrieved with the FieldErrors function.
+type HasFieldErrors interface {
+	GetFieldErrors() []ValidationError
+}
+
+// FieldErrors finds the first HasFieldErrors in the Causer chain of the error.
+// A ValidationError by itself gives a single field.
+// It returns nil if there are no invalid fields.
+func FieldErrors(err error) []ValidationError {
+	var fields []ValidationError
+	errors.Find(err, func(err error) bool {
+		switch found := err.(type) {
+		case HasFieldErrors:
+			fields = found.GetFieldErrors()
+		case ValidationError:
+			fields = []ValidationError{found}
+		default:
+			return false
+		}
+		return true
+	})
+	return fields
+}
+
+// InvalidFieldsClientData is the client data of NewInvalidInputErrFields.
+type InvalidFieldsClientData struct {
+	Fields []ValidationError `json:"fields"`
+}
+
+// invalidFieldsErr gives the code InvalidInputCode.
+// The Fields are sent to the client.
+type invalidFieldsErr struct {
+	fields []ValidationError
+}
+
+// NewInvalidInputErrFields creates an error with InvalidInputCode which gives HTTP 400.
+// Each invalid field is given in the client data as InvalidFieldsClientData
+// so that all of them appear in one response.
+func NewInvalidInputErrFields(fields ...ValidationError) ErrorCode {
+	return invalidFieldsErr{fields: fields}
+}
+
+func (e invalidFieldsErr) Error() string {
+	msgs := make([]string, len(e.fields))
+	for i, field := range e.fields {
+		msgs[i] = field.Error()
+	}
+	return "invalid input: " + strings.Join(msgs, "; ")
+}
+
+// Code returns InvalidInputCode
+func (e invalidFieldsErr) Code() Code {
+	return InvalidInputCode
+}
+
+// GetFieldErrors satisfies the HasFieldErrors interface
+func (e invalidFieldsErr) GetFieldErrors() []ValidationError {
+	return e.fields
+}
+
+// GetClientData returns InvalidFieldsClientData.
+// Fields is an empty (rather than nil) slice when there are no fields.
+func (e invalidFieldsErr) GetClientData() interface{} {
+	fields := e.fields
+	if fields == nil {
+		fields = []ValidationError{}
+	}
+	return InvalidFieldsClientData{Fields: fields}
+}
+
+var _ ErrorCode = (*invalidFieldsErr)(nil)      // assert implements interface
+var _ HasClientData = (*invalidFieldsErr)(nil)  // assert implements interface
+var _ HasFieldErrors = (*invalidFieldsErr)(nil) // assert implements interface
@@ -0,0 +1,104 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"reflect"
+)
+
+// FindCode finds the first ErrorCode in the chain of an error.
+// The chain is followed with the Causer interface and with the Unwrap methods of the standard library,
+// so it works through errors wrapped with fmt.Errorf("%w") as well as with pingcap/errors.
+// An error that wraps multiple errors (Unwrap() []error) is searched depth first.
+// A cycle in the chain is not followed twice, and the search gives up after maxFindDepth errors
+// in case of a cycle through a value that cannot be identified (such as a struct holding a slice of errors).
+// Unlike CodeChain, this does not combine multiple codes: the outermost ErrorCode is returned.
+// The second return value is false if there is no ErrorCode.
+func FindCode(err error) (ErrorCode, bool) {
+	depth := 0
+	return findCode(err, make(map[errIdentity]bool), &depth)
+}
+
+// maxFindDepth is the most errors FindCode will look at.
+const maxFindDepth = 1000
+
+// errIdentity identifies an error by what it refers to rather than by its value,
+// which may not be hashable even if its type is comparable (an interface field holding a slice).
+type errIdentity struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// identity gives the errIdentity of an error that refers to other memory and so can form a cycle:
+// a pointer, map, slice, channel, or func.
+// The second return value is false for other errors, which cannot form a cycle by themselves.
+func identity(err error) (errIdentity, bool) {
+	value := reflect.ValueOf(err)
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return errIdentity{typ: value.Type(), ptr: value.Pointer()}, true
+	case reflect.Slice:
+		return errIdentity{typ: value.Type(), ptr: value.Pointer(), len: value.Len()}, true
+	default:
+		return errIdentity{}, false
+	}
+}
+
+func findCode(err error, visited map[errIdentity]bool, depth *int) (ErrorCode, bool) {
+	for err != nil {
+		if errCode, ok := err.(ErrorCode); ok {
+			return errCode, true
+		}
+		if *depth++; *depth > maxFindDepth {
+			return nil, false
+		}
+		if id, ok := identity(err); ok {
+			if visited[id] {
+				return nil, false
+			}
+			visited[id] = true
+		}
+		switch wrapper := err.(type) {
+		case Causer:
+			err = wrapper.Cause()
+		case interface{ Unwrap() error }:
+			err = wrapper.Unwrap()
+		case interface{ Unwrap() []error }:
+			for _, wrapped := range wrapper.Unwrap() {
+				if errCode, ok := findCode(wrapped, visited, depth); ok {
+					return errCode, true
+				}
+			}
+			return nil, false
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// unwrap gives the next error in the chain of an error.
+// The Causer interface of pingcap/errors is preferred to the Unwrap method of the standard library.
+// An error that wraps multiple errors (Unwrap() []error) is not unwrapped.
+func unwrap(err error) error {
+	switch wrapper := err.(type) {
+	case Causer:
+		return wrapper.Cause()
+	case interface{ Unwrap() error }:
+		return wrapper.Unwrap()
+	default:
+		return nil
+	}
+}
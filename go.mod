@@ -1,14 +1,19 @@
 module github.com/pingcap/errcode
 
+go 1.18
+
 require (
+	github.com/golang/protobuf v1.5.2
 	github.com/pingcap/errors v0.10.1
-	google.golang.org/grpc v1.14.0
+	golang.org/x/text v0.7.0
+	google.golang.org/genproto v0.0.0-20200825200019-8632dd797987
+	google.golang.org/grpc v1.31.0
 )
 
 require (
-	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/net v0.7.0 // indirect
-	golang.org/x/sync v0.3.0 // indirect
-	google.golang.org/genproto v0.0.0-20181004005441-af9cb2a35e7f // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
 )
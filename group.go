@@ -15,6 +15,8 @@ package errcode
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/pingcap/errors"
 )
@@ -92,10 +94,195 @@ func (e MultiErrCode) GetClientData() interface{} {
 	return ClientData(e.ErrCode)
 }
 
+// Fingerprint identifies errors that should be considered duplicates of each other.
+// For an ErrorCode this is the CodeStr and the Error message.
+// For other errors it is just the Error message.
+func Fingerprint(err error) string {
+	if errCode, ok := err.(ErrorCode); ok {
+		return errCode.Code().CodeStr().String() + ": " + err.Error()
+	}
+	return err.Error()
+}
+
+// Dedup collapses members of the MultiErrCode that have the same Fingerprint.
+// The first occurrence of an error is kept in its original position.
+// An ErrorCode that occurred more than once is wrapped in a DuplicateErrCode that records the count.
+func (e MultiErrCode) Dedup() MultiErrCode {
+	var unique []error
+	var counts []int
+	seen := make(map[string]int)
+	for _, err := range e.Errors() {
+		fingerprint := Fingerprint(err)
+		if i, ok := seen[fingerprint]; ok {
+			counts[i]++
+			continue
+		}
+		seen[fingerprint] = len(unique)
+		unique = append(unique, err)
+		counts = append(counts, 1)
+	}
+	for i, err := range unique {
+		if errCode, ok := err.(ErrorCode); ok && counts[i] > 1 {
+			unique[i] = DuplicateErrCode{Err: errCode, Count: counts[i]}
+		}
+	}
+	return MultiErrCode{ErrCode: unique[0].(ErrorCode), rest: unique[1:]}
+}
+
+// DuplicateErrCode is an ErrorCode that occurred multiple times.
+// It is constructed by MultiErrCode.Dedup.
+type DuplicateErrCode struct {
+	Err   ErrorCode
+	Count int
+}
+
+// Error gives the underlying Err Error with the count appended.
+func (e DuplicateErrCode) Error() string {
+	return fmt.Sprintf("%s (%d times)", e.Err.Error(), e.Count)
+}
+
+// Code returns the underlying Code of Err.
+func (e DuplicateErrCode) Code() Code {
+	return e.Err.Code()
+}
+
+// Cause satisfies the Causer interface
+func (e DuplicateErrCode) Cause() error {
+	return e.Err
+}
+
+// Unwrap gives Err for errors.Is and errors.As.
+func (e DuplicateErrCode) Unwrap() error {
+	return e.Err
+}
+
+// GetClientData returns the ClientData of the underlying Err.
+func (e DuplicateErrCode) GetClientData() interface{} {
+	return ClientData(e.Err)
+}
+
+// keepsClientData marks that the client data is the client data of the duplicated Err.
+func (e DuplicateErrCode) keepsClientData() {}
+
+// DuplicateCount gives the Count of the first DuplicateErrCode in the Causer chain of an error.
+// It gives 0 if the error was not collapsed by MultiErrCode.Dedup.
+func DuplicateCount(err error) int {
+	for ; err != nil; err = unwrap(err) {
+		if duplicate, ok := err.(DuplicateErrCode); ok {
+			return duplicate.Count
+		}
+	}
+	return 0
+}
+
+var _ ErrorCode = (*DuplicateErrCode)(nil)     // assert implements interface
+var _ HasClientData = (*DuplicateErrCode)(nil) // assert implements interface
+var _ Causer = (*DuplicateErrCode)(nil)        // assert implements interface
+
+// CombineHTTP chooses a single HTTP code to represent multiple errors.
+// The most severe HTTP code is chosen:
+// * A 5xx code is chosen over a 4xx code.
+// * Among 5xx codes, 500 is chosen. Otherwise the lowest 5xx code is chosen.
+// * Among 4xx codes the priority is 401, 403, 404, 409,
+//   then the lowest other 4xx code, and finally 400 since it is the most generic.
+//
+// The HTTPStatus of each code is used, so a code marked with SetNoHTTP ranks as 500.
+// Nil errors are ignored. If there are no errors 0 is returned.
+func CombineHTTP(errs ...ErrorCode) int {
+	combined := 0
+	found := false
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		httpCode := err.Code().HTTPStatus()
+		if !found || httpSeverity(httpCode) > httpSeverity(combined) {
+			combined = httpCode
+			found = true
+		}
+	}
+	return combined
+}
+
+var http4xxPriority = []int{
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusConflict,
+}
+
+// httpSeverity ranks HTTP codes for CombineHTTP: a higher rank is more severe.
+func httpSeverity(httpCode int) int {
+	switch {
+	case httpCode == http.StatusInternalServerError:
+		return 3000
+	case httpCode >= 500:
+		return 2000 + (600 - httpCode)
+	case httpCode == http.StatusBadRequest:
+		return 1000
+	case httpCode >= 400:
+		for i, priority := range http4xxPriority {
+			if httpCode == priority {
+				return 1900 - i
+			}
+		}
+		return 1000 + (500 - httpCode)
+	default:
+		return httpCode - 1000
+	}
+}
+
+// AggregateErrCode gives one overarching code to multiple ErrorCodes.
+// This is useful for reporting all the failures of an operation, for example the invalid fields of a form.
+// Unlike a MultiErrCode, the code (and thus the HTTP code) comes from GetCode rather than the first error,
+// and the individual errors are given in the client data.
+type AggregateErrCode struct {
+	GetCode  Code
+	ErrCodes []ErrorCode
+}
+
+// NewAggregateErrCode constructs an AggregateErrCode.
+// It may be given no errors, in which case the Error message is the CodeStr.
+func NewAggregateErrCode(code Code, errCodes ...ErrorCode) AggregateErrCode {
+	return AggregateErrCode{GetCode: code, ErrCodes: errCodes}
+}
+
+var _ ErrorCode = (*AggregateErrCode)(nil)     // assert implements interface
+var _ HasClientData = (*AggregateErrCode)(nil) // assert implements interface
+
+// Error gives the Error of each of the ErrCodes with a semi-colon separation.
+func (e AggregateErrCode) Error() string {
+	if len(e.ErrCodes) == 0 {
+		return e.GetCode.CodeStr().String()
+	}
+	msgs := make([]string, len(e.ErrCodes))
+	for i, errCode := range e.ErrCodes {
+		msgs[i] = errCode.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Code returns the GetCode field
+func (e AggregateErrCode) Code() Code {
+	return e.GetCode
+}
+
+// GetClientData gives the JSONFormat of each of the ErrCodes.
+// This is an empty (rather than nil) slice when there are no ErrCodes.
+func (e AggregateErrCode) GetClientData() interface{} {
+	formats := make([]JSONFormat, len(e.ErrCodes))
+	for i, errCode := range e.ErrCodes {
+		formats[i] = NewJSONFormat(errCode)
+	}
+	return formats
+}
+
 // CodeChain resolves an error chain down to a chain of just error codes
 // Any ErrorGroups found are converted to a MultiErrCode.
 // Passed over error inforation is retained using ChainContext.
 // If a code was overidden in the chain, it will show up as a MultiErrCode.
+// The chain is followed with the Causer interface used by pingcap/errors (for example errors.Annotate)
+// and with the Unwrap method of the standard library (for example fmt.Errorf("%w")).
 func CodeChain(err error) ErrorCode {
 	var code ErrorCode
 	currentErr := err
@@ -139,7 +326,7 @@ func CodeChain(err error) ErrorCode {
 				chainErrCode(codeGroup)
 			}
 		}
-		err = errors.Unwrap(err)
+		err = unwrap(err)
 	}
 
 	return code
@@ -166,7 +353,7 @@ func (err ChainContext) Error() string {
 
 // Cause satisfies the Causer interface
 func (err ChainContext) Cause() error {
-	if wrapped := errors.Unwrap(err.Top); wrapped != nil {
+	if wrapped := unwrap(err.Top); wrapped != nil {
 		return wrapped
 	}
 	return err.ErrCode
@@ -4,7 +4,7 @@
 // Note that not all GRPC codes are mapped right now: you are welcome to contribute more.
 // Available mappings are documented here: https://cloud.google.com/apis/design/errors
 //
-// The init functiom performs the mapping and is reproduced here:
+// The init functiom performs the mapping and is equivalent to:
 //
 //	SetCode(errcode.InternalCode, codes.Internal)
 //	SetCode(errcode.InvalidInputCode, codes.InvalidArgument)
@@ -15,11 +15,23 @@
 //	SetCode(errcode.AlreadyExistsCode, codes.AlreadyExists)
 //	SetCode(errcode.OutOfRangeCode, codes.OutOfRange)
 //	SetCode(errcode.UnimplementedCode, codes.Unimplemented)
+//	SetCode(errcode.TimeoutCode, codes.DeadlineExceeded)
+//	SetCode(errcode.UnavailableCode, codes.Unavailable)
+//	SetCode(errcode.RateLimitCode, codes.ResourceExhausted)
+//
+// CodeForGRPC gives the reverse of this mapping.
+// The GRPC code name is also added to the "grpc" field of errcode.ExportCatalog.
 package grpc
 
 import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
 	"github.com/pingcap/errcode"
 	"github.com/pingcap/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -53,48 +65,237 @@ var _ errcode.Causer = (*codeStatus)(nil)     // assert implements interface
 
 
 // WrapAsGRPC constructs a value that responds as both an ErrorCode and as a GRPC status
-func WrapAsGRPC(code errcode.ErrorCode) ErrorCodeStatus {
-	return codeStatus{code}
+// The error is normalized with errcode.Coerce, so an error that wraps an ErrorCode keeps its code.
+// An error without an ErrorCode is wrapped with errcode.NewInternalErr,
+// which records a stack trace starting at the caller of WrapAsGRPC.
+// A nil error gives nil.
+func WrapAsGRPC(err error) ErrorCodeStatus {
+	if err == nil {
+		return nil
+	}
+	return codeStatus{errcode.CoerceDepth(err, 1)}
 }
 
 // Status creates a GRPC Status object from an ErrorCode.
+// The message is given by errcode.UserMsg.
+// Any errcode.Remediation steps are given as the links of a Help detail.
+//
+// The GRPC code is always given by GetCode, even for a code that is not an error on the HTTP side
+// (a 2xx HTTP code such as DegradedCode).
+// An OK status would be dropped by GRPC: status.Err gives nil for it and its details are not sent.
+//
+// The errcode.OriginService is given as the Domain of an ErrorInfo detail
+// along with the CodeStr so that FromStatus can reconstruct the error.
+// An errcode.ConflictDetail is given as a ResourceInfo detail
+// with the resource as the ResourceType and the key as the ResourceName.
+// The errcode.FieldErrors are given as the FieldViolations of a BadRequest detail.
+// These details come from client data, so they are left out for a redacted code (see errcode.SetRedacted).
 // TODO: add more information in the details fields.
 func Status(code errcode.ErrorCode) *status.Status {
-	return status.New(GetCode(code.Code()), code.Error())
+	st := status.New(GetCode(code.Code()), errcode.UserMsg(code))
+	help := &errdetails.Help{}
+	for _, step := range errcode.Remediation(code) {
+		help.Links = append(help.Links, &errdetails.Help_Link{Description: step})
+	}
+	var details []proto.Message
+	if len(help.Links) > 0 {
+		details = append(details, help)
+	}
+	if origin := errcode.OriginService(code); origin != "" {
+		details = append(details, errorInfo(code.Code(), origin))
+	}
+	if !code.Code().IsRedacted() {
+		details = append(details, clientDataDetails(code)...)
+	}
+	if len(details) == 0 {
+		return st
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// clientDataDetails gives the details that come from the client data of an error.
+func clientDataDetails(code errcode.ErrorCode) []proto.Message {
+	var details []proto.Message
+	if conflict, ok := errcode.ConflictDetail(code); ok {
+		details = append(details, &errdetails.ResourceInfo{ResourceType: conflict.Resource, ResourceName: conflict.Key})
+	}
+	if fields := errcode.FieldErrors(code); len(fields) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, field := range fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Reason})
+		}
+		details = append(details, badRequest)
+	}
+	return details
+}
+
+// codeMetaDataKey is the key of the CodeStr in the Metadata of an ErrorInfo detail.
+const codeMetaDataKey = "code"
+
+// errorInfo gives an ErrorInfo detail for a code from a service.
+// The Reason is the CodeStr in the upper case form GRPC expects: "state.exists" becomes "STATE_EXISTS".
+func errorInfo(code errcode.Code, origin string) *errdetails.ErrorInfo {
+	codeStr := code.CodeStr().String()
+	reason := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(codeStr))
+	return &errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   origin,
+		Metadata: map[string]string{codeMetaDataKey: codeStr},
+	}
+}
+
+// FromStatus reconstructs an ErrorCode from a GRPC status, for example one received from another service.
+// The code is taken from an ErrorInfo detail created by Status.
+// If the code is not registered or there is no ErrorInfo, the code is given by CodeForGRPC.
+// The origin service given by the ErrorInfo is kept with errcode.WithOriginService.
+// The error message is the status message.
+// An OK status gives nil.
+func FromStatus(st *status.Status) errcode.ErrorCode {
+	if st.Code() == codes.OK {
+		return nil
+	}
+	var info *errdetails.ErrorInfo
+	for _, detail := range st.Details() {
+		if found, ok := detail.(*errdetails.ErrorInfo); ok {
+			info = found
+			break
+		}
+	}
+	code, ok := errcode.Code{}, false
+	if info != nil {
+		code, ok = errcode.LookupCode(errcode.CodeStr(info.Metadata[codeMetaDataKey]))
+	}
+	if !ok {
+		code = CodeForGRPC(st.Code())
+	}
+	var errCode errcode.ErrorCode = errcode.NewCodedError(errors.New(st.Message()), code)
+	if info != nil && info.Domain != "" {
+		errCode = errcode.WithOriginService(errCode, info.Domain)
+	}
+	return errCode
 }
 
 var grpcMetaData = make(errcode.MetaData)
 
+// checkCode checks that a GRPC code is one of the known codes, OK (0) to Unauthenticated (16).
+func checkCode(grpcCode codes.Code) error {
+	if grpcCode > codes.Unauthenticated {
+		return fmt.Errorf("GRPC code %d is not in the range 0-16", uint32(grpcCode))
+	}
+	return nil
+}
+
 // SetCode adds a GRPC code to the meta data of a code.
 // The code can be retrieved with GRPCCode.
+// Panic if the GRPC code is not a known code, for example an incorrectly cast int.
 // Panic if the metadata is already set for the code.
 // Returns itself.
 func SetCode(code errcode.Code, grpcCode codes.Code) errcode.Code {
+	if err := checkCode(grpcCode); err != nil {
+		panic(errors.Annotatef(err, "SetGRPC for code %v", code.CodeStr()))
+	}
 	if err := code.SetMetaData(grpcMetaData, grpcCode); err != nil {
 		panic(errors.Annotate(err, "SetGRPC"))
 	}
 	return code
 }
 
+// HasExplicitCode tells whether a GRPC code was set for the code itself with SetCode
+// rather than inherited from an ancestor or defaulted.
+func HasExplicitCode(code errcode.Code) bool {
+	_, ok := code.GetMetaData(grpcMetaData)
+	return ok
+}
+
+// CodeMetaData gives the meta data for a GRPC code to use with errcode.CodeBuilder:
+//
+//	errcode.NewCodeBuilder("state.exists").Parent(errcode.StateCode).MetaData(grpc.CodeMetaData(codes.AlreadyExists))
+//
+// Panic if the GRPC code is not a known code.
+func CodeMetaData(grpcCode codes.Code) (errcode.MetaData, interface{}) {
+	if err := checkCode(grpcCode); err != nil {
+		panic(errors.Annotate(err, "CodeMetaData"))
+	}
+	return grpcMetaData, grpcCode
+}
+
+// RequireCode gives an errcode.CodeRule that a code or one of its ancestors has a GRPC code
+// rather than silently defaulting to Unknown (see SetDefaultCode):
+//
+//	errs := errcode.ValidateCodes(errcode.RequireHTTP, grpc.RequireCode())
+func RequireCode() errcode.CodeRule {
+	return errcode.RequireMetaData(grpcMetaData, "GRPC code")
+}
+
+// defaultCode is the codes.Code given by GetCode for a code without a GRPC code.
+// It is accessed atomically since GetCode may run concurrently with SetDefaultCode.
+var defaultCode = uint32(codes.Unknown)
+
+// SetDefaultCode changes the GRPC code given by GetCode
+// when neither a code nor its ancestors have a GRPC code.
+// The default is Unknown (Code 2).
+// This is safe to call concurrently with GetCode.
+func SetDefaultCode(grpcCode codes.Code) {
+	atomic.StoreUint32(&defaultCode, uint32(grpcCode))
+}
+
 // GetCode retrieves the GRPC code for a code or its first ancestor with a GRPC code.
-// If none are specified, it defaults to Unkown (Code 2).
+// If none are specified, it defaults to Unkown (Code 2) unless changed with SetDefaultCode.
 // The return of this is a GRPC codes package Code, not an errcode.Code
 func GetCode(code errcode.Code) codes.Code {
 	grpcCode := code.MetaDataFromAncestors(grpcMetaData)
 	if grpcCode == nil {
-		return codes.Unknown
+		return codes.Code(atomic.LoadUint32(&defaultCode))
 	}
 	return grpcCode.(codes.Code)
 }
 
+// CodeName gives the name of the GRPC code of a code, for example "NotFound".
+// This is the String() of GetCode.
+func CodeName(code errcode.Code) string {
+	return GetCode(code).String()
+}
+
+// builtinCodes is the mapping of the standard error codes to GRPC codes.
+// It is the source of truth for both SetCode in init and the reverse mapping of CodeForGRPC.
+var builtinCodes = []struct {
+	code     errcode.Code
+	grpcCode codes.Code
+}{
+	{errcode.InternalCode, codes.Internal},
+	{errcode.InvalidInputCode, codes.InvalidArgument},
+	{errcode.NotFoundCode, codes.NotFound},
+	{errcode.StateCode, codes.FailedPrecondition},
+	{errcode.ForbiddenCode, codes.PermissionDenied},
+	{errcode.NotAuthenticatedCode, codes.Unauthenticated},
+	{errcode.AlreadyExistsCode, codes.AlreadyExists},
+	{errcode.OutOfRangeCode, codes.OutOfRange},
+	{errcode.UnimplementedCode, codes.Unimplemented},
+	{errcode.TimeoutCode, codes.DeadlineExceeded},
+	{errcode.UnavailableCode, codes.Unavailable},
+	{errcode.RateLimitCode, codes.ResourceExhausted},
+}
+
+// reverseCodes maps a GRPC code back to the standard error code for it.
+var reverseCodes = make(map[codes.Code]errcode.Code, len(builtinCodes))
+
+// CodeForGRPC gives the standard error code that maps to a GRPC code.
+// This is useful for turning a GRPC status from a downstream service into an ErrorCode.
+// A GRPC code without a mapping (for example Unknown) gives InternalCode.
+func CodeForGRPC(grpcCode codes.Code) errcode.Code {
+	if code, ok := reverseCodes[grpcCode]; ok {
+		return code
+	}
+	return errcode.InternalCode
+}
+
 func init() {
-	SetCode(errcode.InternalCode, codes.Internal)
-	SetCode(errcode.InvalidInputCode, codes.InvalidArgument)
-	SetCode(errcode.NotFoundCode, codes.NotFound)
-	SetCode(errcode.StateCode, codes.FailedPrecondition)
-	SetCode(errcode.ForbiddenCode, codes.PermissionDenied)
-	SetCode(errcode.NotAuthenticatedCode, codes.Unauthenticated)
-	SetCode(errcode.AlreadyExistsCode, codes.AlreadyExists)
-	SetCode(errcode.OutOfRangeCode, codes.OutOfRange)
-	SetCode(errcode.UnimplementedCode, codes.Unimplemented)
+	errcode.RegisterCatalogField("grpc", CodeName)
+	for _, builtin := range builtinCodes {
+		SetCode(builtin.code, builtin.grpcCode)
+		reverseCodes[builtin.grpcCode] = builtin.code
+	}
 }
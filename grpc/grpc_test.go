@@ -14,12 +14,19 @@
 package grpc_test
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/pingcap/errcode"
+	grpctest "github.com/pingcap/errcode/errcodetest/grpc"
 	"github.com/pingcap/errcode/grpc"
+	errcodehttp "github.com/pingcap/errcode/http"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	gogrpc "google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Test setting the HTTP code
@@ -43,6 +50,34 @@ func TestGrpcErrorCode(t *testing.T) {
 func TestWrapAsGrpc(t *testing.T) {
 	err := grpc.WrapAsGRPC(errcode.NewInternalErr(fmt.Errorf("wrap me up")))
 	AssertGRPCCode(t, err, codes.Internal)
+
+	plain := grpc.WrapAsGRPC(fmt.Errorf("plain"))
+	AssertGRPCCode(t, plain, codes.Internal)
+	if plain.GRPCStatus().Code() != codes.Internal {
+		t.Errorf("expected a GRPC status of Internal but got %v", plain.GRPCStatus().Code())
+	}
+	if stack := errcode.StackTrace(plain); len(stack) == 0 || !strings.Contains(fmt.Sprintf("%n", stack[0]), "TestWrapAsGrpc") {
+		t.Errorf("expected a plain error to record a stack trace starting at the caller, got %v", stack)
+	}
+
+	if grpc.WrapAsGRPC(nil) != nil {
+		t.Errorf("expected a nil error to give nil")
+	}
+
+	// WrapAsGRPC and the HTTP responder agree on a wrapped ErrorCode through errcode.Coerce
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	wrapped := fmt.Errorf("ctx: %w", notFound)
+	status := grpc.WrapAsGRPC(wrapped)
+	coerced := errcode.Coerce(wrapped)
+	if !status.Code().Equal(coerced.Code()) || status.GRPCStatus().Code() != codes.NotFound {
+		t.Errorf("expected NotFound from both Coerce and WrapAsGRPC but got %v and %v", coerced.Code(), status.Code())
+	}
+	if httpStatus := errcodehttp.Status(coerced); httpStatus != 404 {
+		t.Errorf("expected an HTTP status of 404 but got %v", httpStatus)
+	}
+
+	aggregate := errcode.NewAggregateErrCode(errcode.InvalidInputCode, notFound)
+	AssertGRPCCode(t, grpc.WrapAsGRPC(aggregate), codes.InvalidArgument)
 }
 
 func AssertGRPCCode(t *testing.T, code errcode.ErrorCode, grpcCode codes.Code) {
@@ -52,3 +87,347 @@ func AssertGRPCCode(t *testing.T, code errcode.ErrorCode, grpcCode codes.Code) {
 		t.Errorf("excpected HTTP Code %v but got %v", grpcCode, expected)
 	}
 }
+
+func TestTimeoutGrpcCode(t *testing.T) {
+	err := errcode.NewTimeoutErr(fmt.Errorf("deadline"))
+	AssertGRPCCode(t, err, codes.DeadlineExceeded)
+	AssertGRPCCode(t, errcode.NewTimeoutErr(err), codes.DeadlineExceeded)
+}
+
+func TestUnavailableGrpcCode(t *testing.T) {
+	AssertGRPCCode(t, errcode.NewUnavailableErr(fmt.Errorf("down")), codes.Unavailable)
+}
+
+var grpcNamespaceUser = errcode.NewNamespace("grpcns").Child(errcode.NotFoundCode, "user")
+
+func TestNamespaceChildGrpcCode(t *testing.T) {
+	AssertGRPCCode(t, errcode.NewCodedError(fmt.Errorf("no user"), grpcNamespaceUser), codes.NotFound)
+}
+
+func TestRateLimitGrpcCode(t *testing.T) {
+	AssertGRPCCode(t, errcode.NewRateLimitErr(fmt.Errorf("slow down")), codes.ResourceExhausted)
+}
+
+func TestPaymentRequiredGrpcCode(t *testing.T) {
+	AssertGRPCCode(t, errcode.NewPaymentRequiredErr("trial_expired"), codes.PermissionDenied)
+}
+
+type UnmappedError struct{}
+
+func (e UnmappedError) Error() string { return "error" }
+
+var unmappedCode = errcode.NewCode("grpcunmapped")
+
+func (e UnmappedError) Code() errcode.Code {
+	return unmappedCode
+}
+
+func TestSetDefaultCode(t *testing.T) {
+	AssertGRPCCode(t, UnmappedError{}, codes.Unknown)
+	grpc.SetDefaultCode(codes.Internal)
+	defer grpc.SetDefaultCode(codes.Unknown)
+	AssertGRPCCode(t, UnmappedError{}, codes.Internal)
+	AssertGRPCCode(t, GRPCError{}, codes.Aborted)
+	AssertGRPCCode(t, errcode.NewNotFoundErr(fmt.Errorf("missing")), codes.NotFound)
+}
+
+func TestRequireCode(t *testing.T) {
+	registry := errcode.NewRegistry()
+	for _, code := range []errcode.Code{errcode.NotFoundCode, codeAborted, unmappedCode} {
+		if err := registry.Register(code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := registry.Validate(grpc.RequireCode())
+	if len(errs) != 1 || errs[0].Error() != "code grpcunmapped and its ancestors have no GRPC code" {
+		t.Errorf("expected only the unmapped code to fail but got %v", errs)
+	}
+	if err := grpc.RequireCode()(errcode.DegradedCode); err != nil {
+		t.Errorf("expected a child to inherit the GRPC code but got %v", err)
+	}
+}
+
+func TestCodeName(t *testing.T) {
+	for code, name := range map[errcode.Code]string{
+		errcode.InternalCode:      "Internal",
+		errcode.NotFoundCode:      "NotFound",
+		errcode.AlreadyExistsCode: "AlreadyExists",
+		codeAborted:               "Aborted",
+	} {
+		if got := grpc.CodeName(code); got != name {
+			t.Errorf("expected GRPC code name %v but got %v", name, got)
+		}
+	}
+}
+
+var redactedCode = errcode.NewCode("grpcredacted").SetRedacted(true)
+
+func TestStatusUserMsg(t *testing.T) {
+	err := errcode.NewInternalErr(fmt.Errorf("connection to db-3 refused"))
+	if msg := grpc.Status(err).Message(); msg != "Internal Server Error" {
+		t.Errorf("expected the generic internal message but got %v", msg)
+	}
+	redacted := errcode.NewCodedError(fmt.Errorf("password=hunter2"), redactedCode)
+	if msg := grpc.Status(redacted).Message(); msg != "Bad Request" {
+		t.Errorf("expected the status text for a redacted code but got %v", msg)
+	}
+	unimplemented := errcode.NewUnimplementedErr(fmt.Errorf("password=hunter2"))
+	if msg := grpc.Status(unimplemented).Message(); msg != "Not Implemented" {
+		t.Errorf("expected the generic unimplemented message but got %v", msg)
+	}
+	err = errcode.WithUserMsg(err, "please try again later")
+	if msg := grpc.Status(err).Message(); msg != "please try again later" {
+		t.Errorf("expected the user message but got %v", msg)
+	}
+}
+
+func TestStatusRemediation(t *testing.T) {
+	st := grpc.Status(errcode.NewNotFoundErr(fmt.Errorf("missing")))
+	if len(st.Details()) != 0 {
+		t.Errorf("expected no details but got %v", st.Details())
+	}
+
+	err := errcode.WithRemediation(errcode.NewNotFoundErr(fmt.Errorf("missing")), []string{"create it first"})
+	st = grpc.Status(err)
+	grpctest.RequireDetail(t, st, &errdetails.Help{Links: []*errdetails.Help_Link{{Description: "create it first"}}})
+}
+
+func TestStatusConflict(t *testing.T) {
+	st := grpc.Status(errcode.NewAlreadyExistsErr(fmt.Errorf("exists"), "user", "alice"))
+	if st.Code() != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists but got %v", st.Code())
+	}
+	grpctest.RequireDetail(t, st, &errdetails.ResourceInfo{ResourceType: "user", ResourceName: "alice"})
+}
+
+func TestStatusFieldErrors(t *testing.T) {
+	st := grpc.Status(errcode.NewInvalidInputErrFields(
+		errcode.ValidationError{Field: "name", Reason: "required"},
+		errcode.ValidationError{Field: "age", Reason: "must be positive"},
+	))
+	if st.Code() != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument but got %v", st.Code())
+	}
+	grpctest.RequireDetail(t, st, &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+		{Field: "name", Description: "required"},
+		{Field: "age", Description: "must be positive"},
+	}})
+}
+
+func TestOutOfRangeGrpcCode(t *testing.T) {
+	err := errcode.NewOutOfRangeErr(fmt.Errorf("offset past the end"), 0, 99, 150)
+	if st := grpc.Status(err); st.Code() != codes.OutOfRange {
+		t.Errorf("expected OutOfRange but got %v", st.Code())
+	}
+}
+
+var redactedExistsCode = errcode.AlreadyExistsCode.Child("state.exists.secret").SetRedacted(true)
+
+func TestStatusRedacted(t *testing.T) {
+	st := grpc.Status(errcode.NewAlreadyExistsErr(redactedExistsCode.Err(), "user", "alice"))
+	if st.Code() != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists but got %v", st.Code())
+	}
+	if len(st.Details()) != 0 {
+		t.Errorf("expected no details but got %v", st.Details())
+	}
+}
+
+func TestNotAuthenticatedChildrenGrpcCode(t *testing.T) {
+	for _, errCode := range []errcode.ErrorCode{
+		errcode.NewTokenExpiredErr(fmt.Errorf("token expired")),
+		errcode.NewInvalidCredentialsErr(fmt.Errorf("bad signature")),
+	} {
+		if st := grpc.Status(errCode); st.Code() != codes.Unauthenticated {
+			t.Errorf("expected Unauthenticated for %v but got %v", errCode.Code().CodeStr(), st.Code())
+		}
+	}
+}
+
+func TestForbiddenScopeGrpcCode(t *testing.T) {
+	err := errcode.NewForbiddenErrScope(fmt.Errorf("cannot delete"), "repo:delete")
+	if st := grpc.Status(err); st.Code() != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied but got %v", st.Code())
+	}
+}
+
+func TestToFieldsGrpcCode(t *testing.T) {
+	fields := errcode.ToFields(errcode.NewNotFoundErr(fmt.Errorf("missing")))
+	if fields["grpc_code"] != "NotFound" {
+		t.Errorf("expected grpc_code NotFound but got %v", fields["grpc_code"])
+	}
+}
+
+func TestHasExplicitCode(t *testing.T) {
+	if !grpc.HasExplicitCode(errcode.StateCode) {
+		t.Errorf("expected StateCode to have an explicit GRPC code")
+	}
+	// OutOfRangeCode maps to OutOfRange itself rather than inheriting FailedPrecondition
+	if !grpc.HasExplicitCode(errcode.OutOfRangeCode) {
+		t.Errorf("expected OutOfRangeCode to have an explicit GRPC code")
+	}
+	if grpc.HasExplicitCode(errcode.PaymentRequiredCode) {
+		t.Errorf("expected PaymentRequiredCode to inherit its GRPC code")
+	}
+}
+
+var (
+	invalidGrpcCode = errcode.StateCode.Child("state.grpcinvalid")
+	validGrpcCode   = grpc.SetCode(errcode.StateCode.Child("state.grpcvalid"), codes.Aborted)
+)
+
+func TestSetCodeRange(t *testing.T) {
+	AssertPanics(t, "SetCode(17)", func() { grpc.SetCode(invalidGrpcCode, codes.Code(17)) })
+	AssertPanics(t, "CodeMetaData(100)", func() { grpc.CodeMetaData(codes.Code(100)) })
+	if grpc.HasExplicitCode(invalidGrpcCode) {
+		t.Errorf("expected an invalid GRPC code to not be set")
+	}
+	if grpc.GetCode(validGrpcCode) != codes.Aborted {
+		t.Errorf("expected Aborted but got %v", grpc.GetCode(validGrpcCode))
+	}
+	// setting a code twice still panics
+	AssertPanics(t, "SetCode twice", func() { grpc.SetCode(validGrpcCode, codes.Canceled) })
+}
+
+func AssertPanics(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected %v to panic", name)
+		}
+	}()
+	fn()
+}
+
+func TestCodeForGRPC(t *testing.T) {
+	expected := map[codes.Code]errcode.Code{
+		codes.NotFound:           errcode.NotFoundCode,
+		codes.InvalidArgument:    errcode.InvalidInputCode,
+		codes.FailedPrecondition: errcode.StateCode,
+		codes.PermissionDenied:   errcode.ForbiddenCode,
+		codes.DeadlineExceeded:   errcode.TimeoutCode,
+		codes.Unavailable:        errcode.UnavailableCode,
+		codes.ResourceExhausted:  errcode.RateLimitCode,
+		codes.Internal:           errcode.InternalCode,
+		codes.Unknown:            errcode.InternalCode,
+		codes.DataLoss:           errcode.InternalCode,
+	}
+	for grpcCode, code := range expected {
+		if got := grpc.CodeForGRPC(grpcCode); !got.Equal(code) {
+			t.Errorf("expected CodeForGRPC(%v) to be %v but got %v", grpcCode, code.CodeStr(), got.CodeStr())
+		}
+	}
+	// The reverse mapping agrees with the forward mapping
+	for _, code := range []errcode.Code{errcode.NotFoundCode, errcode.OutOfRangeCode, errcode.UnimplementedCode} {
+		if got := grpc.CodeForGRPC(grpc.GetCode(code)); !got.Equal(code) {
+			t.Errorf("expected CodeForGRPC(GetCode(%v)) to round trip but got %v", code.CodeStr(), got.CodeStr())
+		}
+	}
+}
+
+func TestStatusDegraded(t *testing.T) {
+	err := errcode.WithOriginService(errcode.WithRemediation(errcode.NewDegradedErr("recommendations"), []string{"try again later"}), "recommender")
+	st := grpc.Status(err)
+	if st.Code() != codes.FailedPrecondition {
+		t.Errorf("expected a degraded error to give FailedPrecondition but got %v", st.Code())
+	}
+
+	// The status survives being sent as an error
+	sent := st.Err()
+	if sent == nil {
+		t.Fatal("expected a degraded error to give a non-nil status error")
+	}
+	received := status.Convert(sent)
+	grpctest.RequireDetail(t, received, &errdetails.Help{Links: []*errdetails.Help_Link{{Description: "try again later"}}})
+	if errCode := grpc.FromStatus(received); errCode == nil || !errCode.Code().Equal(errcode.DegradedCode) {
+		t.Errorf("expected the degraded code to round trip but got %v", errCode)
+	}
+	if wrapped := status.Convert(grpc.WrapAsGRPC(err)); wrapped.Code() != codes.FailedPrecondition {
+		t.Errorf("expected WrapAsGRPC to give FailedPrecondition but got %v", wrapped.Code())
+	}
+}
+
+func TestFromStatusOrigin(t *testing.T) {
+	// users produces the error and sends it to the gateway
+	produced := errcode.WithOriginService(errcode.NewNotFoundErr(fmt.Errorf("no such user")), "users")
+	st := grpc.Status(produced)
+	grpctest.RequireDetail(t, st, &errdetails.ErrorInfo{
+		Reason:   "MISSING",
+		Domain:   "users",
+		Metadata: map[string]string{"code": "missing"},
+	})
+	atGateway := grpc.FromStatus(st)
+
+	// the gateway adds its own origin, which does not replace the first
+	st = grpc.Status(errcode.WithOriginService(atGateway, "gateway"))
+	received := grpc.FromStatus(st)
+	if origin := errcode.OriginService(received); origin != "users" {
+		t.Errorf("expected the origin users but got %v", origin)
+	}
+	if !received.Code().Equal(errcode.NotFoundCode) || received.Error() != "no such user" {
+		t.Errorf("unexpected reconstructed error %v %v", received.Code().CodeStr(), received)
+	}
+
+	plain := grpc.FromStatus(status.New(codes.Unavailable, "down"))
+	if !plain.Code().Equal(errcode.UnavailableCode) || errcode.OriginService(plain) != "" {
+		t.Errorf("unexpected reconstructed error %v %v", plain.Code().CodeStr(), plain)
+	}
+	if grpc.FromStatus(status.New(codes.OK, "")) != nil {
+		t.Errorf("expected an OK status to give nil")
+	}
+}
+
+func TestCatalogField(t *testing.T) {
+	for _, entry := range errcode.ExportCatalog() {
+		if entry.Code == errcode.InternalCode.CodeStr() {
+			if entry.Parent != "" || entry.HTTP != 500 || entry.Fields["grpc"] != "Internal" {
+				t.Errorf("unexpected catalog entry %#v", entry)
+			}
+			return
+		}
+	}
+	t.Errorf("expected an entry for InternalCode")
+}
+
+var builtCode = errcode.NewCodeBuilder("state.grpcbuilt").
+	Parent(errcode.StateCode).
+	MetaData(grpc.CodeMetaData(codes.Aborted)).
+	Build()
+
+func TestCodeMetaData(t *testing.T) {
+	if code := grpc.GetCode(builtCode); code != codes.Aborted {
+		t.Errorf("expected the built code to be Aborted but got %v", code)
+	}
+	if !grpc.HasExplicitCode(builtCode) {
+		t.Errorf("expected the built code to have an explicit GRPC code")
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := grpc.UnaryClientInterceptor()
+	invoke := func(invokeErr error) error {
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *gogrpc.ClientConn, opts ...gogrpc.CallOption) error {
+			return invokeErr
+		}
+		return interceptor(context.Background(), "/test.Service/Get", nil, nil, nil, invoker)
+	}
+
+	if err := invoke(nil); err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+
+	serverErr := grpc.Status(errcode.NewNotFoundErr(fmt.Errorf("no user 42"))).Err()
+	err := invoke(serverErr)
+	errCode, ok := err.(errcode.ErrorCode)
+	if !ok {
+		t.Fatalf("expected an ErrorCode but got %T", err)
+	}
+	if !errCode.Code().Equal(errcode.NotFoundCode) || errCode.Error() != "no user 42" {
+		t.Errorf("expected NotFoundCode but got %v: %v", errCode.Code().CodeStr(), errCode)
+	}
+
+	err = invoke(fmt.Errorf("connection refused"))
+	if errCode, ok := err.(errcode.ErrorCode); !ok || !errCode.Code().Equal(errcode.UnavailableCode) {
+		t.Errorf("expected UnavailableCode but got %v", err)
+	}
+}
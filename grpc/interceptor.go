@@ -0,0 +1,51 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+
+	"github.com/pingcap/errcode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor translates the errors of calls to another service into ErrorCodes
+// so that calling code can branch on the code.
+// A GRPC status is converted with FromStatus.
+// An error without a status (for example a connection failure) is wrapped with errcode.NewUnavailableErr.
+// An error that is already an ErrorCode is returned unchanged.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			return nil
+		}
+		return fromClientError(err)
+	}
+}
+
+func fromClientError(err error) error {
+	if _, ok := err.(errcode.ErrorCode); ok {
+		return err
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return errcode.NewUnavailableErr(err)
+	}
+	if errCode := FromStatus(st); errCode != nil {
+		return errCode
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"fmt"
+)
+
+// GuardCode runs fn and converts a panic into a returned error.
+// If fn returns normally, its error is returned unchanged.
+//
+// A panic with an ErrorCode value keeps its code.
+// Any other panic value is wrapped as an internal error.
+// In both cases a stack trace is recorded starting at the frame that panicked,
+// unless the panic value already carries a stack trace.
+func GuardCode(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredStackCode(r)
+		}
+	}()
+	return fn()
+}
+
+// recoveredStackCode must be called directly from a deferred function.
+// The stack skips this function, the deferred function, and runtime.gopanic.
+func recoveredStackCode(recovered interface{}) ErrorCode {
+	const panicFrame = 4
+	if errCode, ok := recovered.(ErrorCode); ok {
+		return NewStackCode(errCode, panicFrame)
+	}
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("panic: %v", recovered)
+	}
+	return internalErr{NewStackCode(CodedError{GetCode: InternalCode, Err: err}, panicFrame)}
+}
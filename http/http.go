@@ -0,0 +1,171 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package http provides helpers for sending error codes as HTTP responses.
+//
+// The HTTP status is taken from the HTTPCode of the error code
+// and the body is the JSON serialization of errcode.NewJSONFormat.
+// WriteProblemJSON instead writes an RFC 7807 problem details document.
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errors"
+	"golang.org/x/text/language"
+)
+
+// defaultCodeHeader is the response header that gives the CodeStr unless changed with SetCodeHeader.
+const defaultCodeHeader = "X-Error-Code"
+
+// codeHeader holds the header name string set with SetCodeHeader.
+// It is an atomic.Value since every WriteError reads it.
+var codeHeader atomic.Value
+
+// SetCodeHeader changes the name of the response header that gives the CodeStr of the error.
+// The default is X-Error-Code. An empty name stops the header from being sent.
+// This is safe to call concurrently with WriteError.
+func SetCodeHeader(name string) {
+	codeHeader.Store(name)
+}
+
+// getCodeHeader gives the header name set with SetCodeHeader or the default.
+func getCodeHeader() string {
+	if name, ok := codeHeader.Load().(string); ok {
+		return name
+	}
+	return defaultCodeHeader
+}
+
+// WriteError writes an ErrorCode as a JSON HTTP response.
+// The status is given by HTTPCode.
+// For a group of errors such as a MultiErrCode, the status is chosen with errcode.CombineHTTP.
+// The CodeStr is also sent in the X-Error-Code header (see SetCodeHeader) for gateways to route and log on.
+// Codes marked with SetNoCache also send headers that prevent caching of the response.
+func WriteError(w http.ResponseWriter, errCode errcode.ErrorCode) error {
+	return writeJSON(w, errCode, errcode.NewJSONFormat(errCode))
+}
+
+// WriteErrorUnprocessable is the same as WriteError but the status is given by errcode.Code.HTTPCodeUnprocessable,
+// so a code marked with SetHTTPUnprocessable is sent as 422 Unprocessable Entity.
+// This is for handlers that distinguish a well formed but semantically invalid request.
+// A group of errors is sent with the same status as WriteError.
+func WriteErrorUnprocessable(w http.ResponseWriter, errCode errcode.ErrorCode) error {
+	code, status := StatusCode(errCode)
+	if _, ok := errCode.(errors.ErrorGroup); !ok {
+		if httpCode := code.HTTPCodeUnprocessable(); httpCode != errcode.NoHTTPCode {
+			status = httpCode
+		}
+	}
+	return writeStatusBody(w, errCode, code, status, "application/json", errcode.NewJSONFormat(errCode))
+}
+
+// WriteLocalizedError is the same as WriteError but the message is localized
+// with errcode.LocalizedUserMsg for the language chosen by AcceptedLanguage.
+func WriteLocalizedError(w http.ResponseWriter, r *http.Request, errCode errcode.ErrorCode) error {
+	format := errcode.NewJSONFormat(errCode)
+	format.Msg = errcode.LocalizedUserMsg(errCode, AcceptedLanguage(r, errCode.Code()))
+	return writeJSON(w, errCode, format)
+}
+
+// AcceptedLanguage chooses the language of the message of a code from the Accept-Language header of a request.
+// The accepted languages are matched in order of their q-weights against the languages
+// that have a message for the code in the errcode.MsgCatalog,
+// so "de, fr;q=0.5" chooses "fr" if there is a French message but no German one.
+// If none of them match, the most preferred language is given
+// so that an error with its own errcode.HasLocalizedMsg can still use it.
+// Without an Accept-Language header the result is language.Und.
+func AcceptedLanguage(r *http.Request, code errcode.Code) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return language.Und
+	}
+	supported := errcode.GetMsgCatalog().Languages(code)
+	if len(supported) == 0 {
+		return tags[0]
+	}
+	_, index, confidence := language.NewMatcher(supported).Match(tags...)
+	if confidence == language.No {
+		return tags[0]
+	}
+	return supported[index]
+}
+
+func writeJSON(w http.ResponseWriter, errCode errcode.ErrorCode, format errcode.JSONFormat) error {
+	return writeBody(w, errCode, "application/json", format)
+}
+
+// writeBody writes the headers and status for an ErrorCode and then the JSON serialization of body.
+// For a group of errors the code header is given by the error that decides the status (see StatusCode),
+// and the response is not cached if any of the errors is marked with SetNoCache.
+func writeBody(w http.ResponseWriter, errCode errcode.ErrorCode, contentType string, body interface{}) error {
+	code, status := StatusCode(errCode)
+	return writeStatusBody(w, errCode, code, status, contentType, body)
+}
+
+// writeStatusBody is writeBody with the code header and status already chosen.
+func writeStatusBody(w http.ResponseWriter, errCode errcode.ErrorCode, code errcode.Code, status int, contentType string, body interface{}) error {
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	if name := getCodeHeader(); name != "" {
+		header.Set(name, code.CodeStr().String())
+	}
+	if noCache(errCode) {
+		header.Set("Cache-Control", "no-store")
+		header.Set("Pragma", "no-cache")
+	}
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(body)
+}
+
+// noCache tells whether the code of the error or of any error in its group is marked with SetNoCache.
+func noCache(errCode errcode.ErrorCode) bool {
+	if _, ok := errCode.(errors.ErrorGroup); !ok {
+		return errCode.Code().NoCache()
+	}
+	for _, member := range errcode.ErrorCodes(errCode) {
+		if member.Code().NoCache() {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusCode gives the HTTP status for an ErrorCode (see Status) along with the code that decides it.
+// For a group of errors this is the code of the first error with the combined status,
+// for example the internal error of a group of a not found error and an internal error.
+func StatusCode(errCode errcode.ErrorCode) (errcode.Code, int) {
+	status := Status(errCode)
+	if _, ok := errCode.(errors.ErrorGroup); ok {
+		for _, member := range errcode.ErrorCodes(errCode) {
+			if member.Code().HTTPStatus() == status {
+				return member.Code(), status
+			}
+		}
+	}
+	return errCode.Code(), status
+}
+
+// Status gives the HTTP status for an ErrorCode.
+// For a group of errors (see errors.ErrorGroup) this is errcode.CombineHTTP of the group.
+// Otherwise it is the HTTPStatus of the code,
+// so a code without an HTTP code (see errcode.SetNoHTTP) is sent as 500.
+func Status(errCode errcode.ErrorCode) int {
+	if _, ok := errCode.(errors.ErrorGroup); ok {
+		return errcode.CombineHTTP(errcode.ErrorCodes(errCode)...)
+	}
+	return errCode.Code().HTTPStatus()
+}
@@ -0,0 +1,458 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errcode/http"
+	"github.com/pingcap/errors"
+	"golang.org/x/text/language"
+)
+
+var noCacheCode = errcode.StateCode.Child("state.nocache").SetNoCache(true)
+
+type NoCacheError struct{}
+
+func (e NoCacheError) Error() string      { return "no cache" }
+func (e NoCacheError) Code() errcode.Code { return noCacheCode }
+
+func TestWriteErrorNoCache(t *testing.T) {
+	rec := AssertWriteError(t, NoCacheError{}, 400)
+	AssertHeader(t, rec, "Cache-Control", "no-store")
+
+	rec = AssertWriteError(t, errcode.NewInternalErr(fmt.Errorf("internal")), 500)
+	AssertHeader(t, rec, "Cache-Control", "no-store")
+
+	rec = AssertWriteError(t, errcode.NewNotFoundErr(fmt.Errorf("missing")), 404)
+	AssertHeader(t, rec, "Cache-Control", "")
+}
+
+func TestWriteErrorCodeHeader(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	rec := AssertWriteError(t, notFound, 404)
+	AssertHeader(t, rec, "X-Error-Code", "missing")
+
+	http.SetCodeHeader("Error-Code")
+	defer http.SetCodeHeader("X-Error-Code")
+	rec = AssertWriteError(t, notFound, 404)
+	AssertHeader(t, rec, "Error-Code", "missing")
+	AssertHeader(t, rec, "X-Error-Code", "")
+
+	http.SetCodeHeader("")
+	rec = AssertWriteError(t, notFound, 404)
+	AssertHeader(t, rec, "Error-Code", "")
+}
+
+func AssertWriteError(t *testing.T, errCode errcode.ErrorCode, status int) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := http.WriteError(rec, errCode); err != nil {
+		t.Fatalf("WriteError failed: %v", err)
+	}
+	if rec.Code != status {
+		t.Errorf("expected status %v but got %v", status, rec.Code)
+	}
+	AssertHeader(t, rec, "Content-Type", "application/json")
+	var body errcode.JSONFormat
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("could not decode body: %v", err)
+	}
+	if body.Code != errCode.Code().CodeStr() {
+		t.Errorf("expected code %v but got %v", errCode.Code().CodeStr(), body.Code)
+	}
+	return rec
+}
+
+func AssertHeader(t *testing.T, rec *httptest.ResponseRecorder, key string, value string) {
+	t.Helper()
+	if got := rec.Header().Get(key); got != value {
+		t.Errorf("expected header %v to be %#v but got %#v", key, value, got)
+	}
+}
+
+func TestNewErrWithRequest(t *testing.T) {
+	req := httptest.NewRequest("POST", "/users/1?token=x", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Request-Id", "abc")
+
+	err := http.NewErrWithRequest(req, fmt.Errorf("plain"))
+	if err.Code().CodeStr() != errcode.InternalCode.CodeStr() {
+		t.Errorf("expected an internal code but got %v", err.Code().CodeStr())
+	}
+	snapshot, ok := http.Request(errors.Annotate(err, "annotated"))
+	if !ok {
+		t.Fatal("expected a request snapshot")
+	}
+	if snapshot.Method != "POST" || snapshot.Path != "/users/1" {
+		t.Errorf("unexpected snapshot %#v", snapshot)
+	}
+	for _, key := range []string{"Authorization", "Cookie"} {
+		if _, ok := snapshot.Header[key]; ok {
+			t.Errorf("expected header %v to be excluded", key)
+		}
+	}
+	if snapshot.Header.Get("X-Request-Id") != "abc" {
+		t.Errorf("expected header X-Request-Id to be recorded")
+	}
+	if req.Header.Get("Authorization") == "" {
+		t.Errorf("expected the request to not be modified")
+	}
+
+	err = http.NewErrWithRequest(req, errcode.NewNotFoundErr(fmt.Errorf("missing")))
+	if err.Code().CodeStr() != errcode.NotFoundCode.CodeStr() {
+		t.Errorf("expected the existing code to be kept but got %v", err.Code().CodeStr())
+	}
+}
+
+func TestWriteErrorGroup(t *testing.T) {
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	internal := errcode.NewInternalErr(fmt.Errorf("internal"))
+	AssertWriteError(t, errcode.Combine(notFound, internal), 500)
+
+	// The headers come from the error that decides the status
+	rec := AssertWriteError(t, errcode.Combine(notFound, internal), 500)
+	AssertHeader(t, rec, "X-Error-Code", "internal")
+	AssertHeader(t, rec, "Cache-Control", "no-store")
+	rec = AssertWriteError(t, errcode.Combine(notFound, errcode.NewInvalidInputErr(fmt.Errorf("bad"))), 404)
+	AssertHeader(t, rec, "X-Error-Code", "missing")
+	AssertHeader(t, rec, "Cache-Control", "")
+}
+
+// hijackRecorder is a ResponseRecorder that can be hijacked
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func TestRecoverer(t *testing.T) {
+	var logged []errcode.ErrorCode
+	recoverer := http.RecovererWithLogger(func(r *gohttp.Request, errCode errcode.ErrorCode) {
+		logged = append(logged, errCode)
+	})
+	serve := func(panicValue interface{}) *httptest.ResponseRecorder {
+		handler := recoverer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+			panic(panicValue)
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+		return rec
+	}
+
+	for _, panicValue := range []interface{}{"secret string", fmt.Errorf("secret error")} {
+		rec := serve(panicValue)
+		if rec.Code != 500 {
+			t.Errorf("expected status 500 but got %v", rec.Code)
+		}
+		var body errcode.JSONFormat
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("could not decode body: %v", err)
+		}
+		if body.Msg != http.PanicMsg || strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("expected a generic message but got %v", rec.Body.String())
+		}
+	}
+	if len(logged) != 2 || !strings.Contains(errors.Cause(logged[0]).Error(), "secret string") {
+		t.Fatalf("expected the recovered value to be logged, got %v", logged)
+	}
+	if _, ok := http.Request(logged[0]); !ok {
+		t.Errorf("expected the request to be logged")
+	}
+
+	rec := serve(errcode.NewNotFoundErr(fmt.Errorf("missing")))
+	if rec.Code != 404 {
+		t.Errorf("expected a panic with an ErrorCode to keep its status, got %v", rec.Code)
+	}
+
+	rec = serve(errcode.NewUnimplementedErr(fmt.Errorf("secret unimplemented")))
+	if rec.Code != 501 || strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("expected a redacted 501 for an internal code but got %v %v", rec.Code, rec.Body.String())
+	}
+
+	logged = nil
+	partial := recoverer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.WriteHeader(202)
+		_, _ = w.Write([]byte("partial"))
+		panic("after write")
+	}))
+	rec = httptest.NewRecorder()
+	partial.ServeHTTP(rec, httptest.NewRequest("GET", "/partial", nil))
+	if rec.Code != 202 || rec.Body.String() != "partial" {
+		t.Errorf("expected the partial response to be left alone but got %v %v", rec.Code, rec.Body.String())
+	}
+	if len(logged) != 1 {
+		t.Errorf("expected the panic after a write to be logged but got %v", logged)
+	}
+
+	// The optional interfaces of the ResponseWriter are kept
+	logged = nil
+	streaming := recoverer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		flusher, ok := w.(gohttp.Flusher)
+		if !ok {
+			t.Fatalf("expected the ResponseWriter to still be a Flusher")
+		}
+		if _, ok := w.(gohttp.Hijacker); ok {
+			t.Errorf("expected the ResponseWriter to not gain a Hijacker")
+		}
+		_, _ = w.Write([]byte("event"))
+		flusher.Flush()
+		panic("after flush")
+	}))
+	rec = httptest.NewRecorder()
+	streaming.ServeHTTP(rec, httptest.NewRequest("GET", "/stream", nil))
+	if !rec.Flushed || rec.Body.String() != "event" || len(logged) != 1 {
+		t.Errorf("expected a flushed partial response and a logged panic but got %v %v", rec.Body.String(), logged)
+	}
+
+	upgrade := recoverer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		hijacker, ok := w.(gohttp.Hijacker)
+		if !ok {
+			t.Fatalf("expected the ResponseWriter to still be a Hijacker")
+		}
+		_, _, _ = hijacker.Hijack()
+		panic("after hijack")
+	}))
+	hijackable := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	upgrade.ServeHTTP(hijackable, httptest.NewRequest("GET", "/ws", nil))
+	if !hijackable.hijacked || hijackable.Body.Len() != 0 {
+		t.Errorf("expected no error to be written to a hijacked connection but got %v", hijackable.Body.String())
+	}
+
+	ok := http.Recoverer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.WriteHeader(204)
+	}))
+	rec = httptest.NewRecorder()
+	ok.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 204 {
+		t.Errorf("expected the handler status but got %v", rec.Code)
+	}
+}
+
+func TestWriteLocalizedError(t *testing.T) {
+	defer errcode.SetMsgCatalog(errcode.GetMsgCatalog())
+	errcode.SetMsgCatalog(errcode.NewMsgCatalog().Add(errcode.NotFoundCode, language.French, "introuvable"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "fr-CA, fr;q=0.9, en;q=0.8")
+	rec := httptest.NewRecorder()
+	if err := http.WriteLocalizedError(rec, req, errcode.NewNotFoundErr(fmt.Errorf("missing"))); err != nil {
+		t.Fatal(err)
+	}
+	var body errcode.JSONFormat
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("could not decode body: %v", err)
+	}
+	if rec.Code != 404 || body.Msg != "introuvable" {
+		t.Errorf("expected a localized 404 but got %v %v", rec.Code, body.Msg)
+	}
+
+	errcode.GetMsgCatalog().Add(errcode.NotFoundCode, language.Spanish, "no encontrado")
+	for header, expected := range map[string]language.Tag{
+		"":                               language.Und,
+		"fr":                             language.French,
+		"de, fr;q=0.5":                   language.French,
+		"fr;q=0.2, es;q=0.8":             language.Spanish,
+		"es-MX":                          language.Spanish,
+		"de;q=0.9, ja":                   language.Japanese,
+		"de, es;q=0.1, fr;q=0.3, en;q=0": language.French,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set("Accept-Language", header)
+		}
+		if got := http.AcceptedLanguage(req, errcode.NotFoundCode); got != expected {
+			t.Errorf("expected %q to choose %v but got %v", header, expected, got)
+		}
+	}
+}
+
+var (
+	unprocessableCode     = errcode.InvalidInputCode.Child("input.httpsemantic").SetHTTPUnprocessable()
+	unprocessableConflict = unprocessableCode.Child("input.httpsemantic.conflict").SetHTTP(gohttp.StatusConflict)
+	unprocessableNoHTTP   = errcode.NewCode("httpsemanticnohttp").SetNoHTTP()
+)
+
+func TestWriteErrorUnprocessable(t *testing.T) {
+	for errCode, expected := range map[errcode.ErrorCode]int{
+		errcode.NewCodedError(errors.New("bad date"), unprocessableCode):  gohttp.StatusUnprocessableEntity,
+		errcode.NewCodedError(errors.New("taken"), unprocessableConflict): gohttp.StatusConflict,
+		errcode.NewNotFoundErr(errors.New("missing")):                     gohttp.StatusNotFound,
+		errcode.NewCodedError(errors.New("no http"), unprocessableNoHTTP): gohttp.StatusInternalServerError,
+	} {
+		rec := httptest.NewRecorder()
+		if err := http.WriteErrorUnprocessable(rec, errCode); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != expected {
+			t.Errorf("expected status %v for %v but got %v", expected, errCode.Code().CodeStr(), rec.Code)
+		}
+		AssertHeader(t, rec, "X-Error-Code", errCode.Code().CodeStr().String())
+	}
+	// WriteError keeps the primary mapping, and so does a group
+	AssertWriteError(t, errcode.NewCodedError(errors.New("bad date"), unprocessableCode), gohttp.StatusBadRequest)
+	rec := httptest.NewRecorder()
+	group := errcode.Combine(errcode.NewCodedError(errors.New("bad date"), unprocessableCode), errcode.NewInvalidInputErr(errors.New("bad")))
+	if err := http.WriteErrorUnprocessable(rec, group); err != nil || rec.Code != gohttp.StatusBadRequest {
+		t.Errorf("expected a group to keep the status of WriteError but got %v %v", rec.Code, err)
+	}
+}
+
+var quotaCode = errcode.StateCode.Child("state.quota").SetHTTP(gohttp.StatusTooManyRequests)
+
+// QuotaError is serialized by reflection
+type QuotaError struct {
+	Resource string `json:"resource"`
+	Limit    int    `json:"limit"`
+}
+
+func (e QuotaError) Error() string      { return "quota exceeded for " + e.Resource }
+func (e QuotaError) Code() errcode.Code { return quotaCode }
+
+// FastQuotaError is the same as QuotaError but uses the MarshalClientData fast path
+type FastQuotaError struct{ QuotaError }
+
+func (e FastQuotaError) GetClientData() interface{} { return e.QuotaError }
+
+func (e FastQuotaError) MarshalClientData() ([]byte, error) {
+	buf := make([]byte, 0, 32+len(e.Resource))
+	buf = append(buf, `{"resource":`...)
+	buf = strconv.AppendQuote(buf, e.Resource)
+	buf = append(buf, `,"limit":`...)
+	buf = strconv.AppendInt(buf, int64(e.Limit), 10)
+	return append(buf, '}'), nil
+}
+
+var _ errcode.HasClientDataMarshal = FastQuotaError{} // assert implements interface
+
+func TestMarshalClientData(t *testing.T) {
+	slow := AssertWriteError(t, QuotaError{Resource: "cpu", Limit: 8}, gohttp.StatusTooManyRequests)
+	fast := AssertWriteError(t, FastQuotaError{QuotaError{Resource: "cpu", Limit: 8}}, gohttp.StatusTooManyRequests)
+	if slow.Body.String() != fast.Body.String() {
+		t.Errorf("expected the same JSON but got\n%v\n%v", slow.Body.String(), fast.Body.String())
+	}
+	if !strings.Contains(fast.Body.String(), `"data":{"resource":"cpu","limit":8}`) {
+		t.Errorf("unexpected JSON %v", fast.Body.String())
+	}
+
+	// The fast path is found through wrappers that keep the client data
+	wrapped := errcode.Op("reserve").AddTo(errcode.NewStackCode(FastQuotaError{QuotaError{Resource: "cpu", Limit: 8}}))
+	if raw, ok := errcode.NewJSONFormat(wrapped).Data.(json.RawMessage); !ok || string(raw) != `{"resource":"cpu","limit":8}` {
+		t.Errorf("expected the MarshalClientData fast path through wrappers but got %#v", errcode.NewJSONFormat(wrapped).Data)
+	}
+	userMsg := errcode.WithUserMsg(errcode.WithCode(wrapped, quotaCode), "slow down")
+	if _, ok := errcode.NewJSONFormat(userMsg).Data.(json.RawMessage); !ok {
+		t.Errorf("expected the MarshalClientData fast path through WithUserMsg but got %#v", errcode.NewJSONFormat(userMsg).Data)
+	}
+	// but not through a wrapper that changes the client data
+	remediated := errcode.WithRemediation(wrapped, []string{"raise the quota"})
+	if _, ok := errcode.NewJSONFormat(remediated).Data.(errcode.RemediationClientData); !ok {
+		t.Errorf("expected RemediationClientData but got %#v", errcode.NewJSONFormat(remediated).Data)
+	}
+	redacted := errcode.WrapRedacted(FastQuotaError{QuotaError{Resource: "cpu", Limit: 8}}, quotaCode, "quota exceeded")
+	if data := errcode.NewJSONFormat(redacted).Data; data != nil {
+		t.Errorf("expected no client data for a redacted wrapper but got %#v", data)
+	}
+}
+
+func BenchmarkWriteErrorReflect(b *testing.B) {
+	benchmarkWriteError(b, QuotaError{Resource: "cpu", Limit: 8})
+}
+
+func BenchmarkWriteErrorMarshalClientData(b *testing.B) {
+	benchmarkWriteError(b, FastQuotaError{QuotaError{Resource: "cpu", Limit: 8}})
+}
+
+func benchmarkWriteError(b *testing.B, errCode errcode.ErrorCode) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := http.WriteError(httptest.NewRecorder(), errCode); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteProblemJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := http.WriteProblemJSON(rec, errcode.NewAlreadyExistsErr(fmt.Errorf("user exists"), "user", "alice")); err != nil {
+		t.Fatal(err)
+	}
+	AssertHeader(t, rec, "Content-Type", "application/problem+json")
+	expected := `{"type":"about:blank","title":"Conflict","status":409,"detail":"user exists","code":"state.exists","data":{"resource":"user","key":"alice"}}` + "\n"
+	if rec.Body.String() != expected {
+		t.Errorf("expected problem %v\ngot %v", expected, rec.Body.String())
+	}
+
+	http.SetProblemTypeBase("https://example.com/errors/")
+	defer http.SetProblemTypeBase("")
+	rec = httptest.NewRecorder()
+	if err := http.WriteProblemJSON(rec, errcode.NewNotFoundErr(fmt.Errorf("no user 42"))); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 404 {
+		t.Errorf("expected status 404 but got %v", rec.Code)
+	}
+	AssertHeader(t, rec, "X-Error-Code", "missing")
+	var problem map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("could not decode body: %v", err)
+	}
+	for key, value := range map[string]interface{}{
+		"type":   "https://example.com/errors/missing",
+		"title":  "Not Found",
+		"status": float64(404),
+		"detail": "no user 42",
+	} {
+		if problem[key] != value {
+			t.Errorf("expected %v to be %v but got %v", key, value, problem[key])
+		}
+	}
+}
+
+var describedCode = errcode.NotFoundCode.Child("missing.problem").SetDescription("The problem was not found.")
+
+func TestProblemTitle(t *testing.T) {
+	err := describedCode.Err()
+	if problem := http.NewProblem(err); problem.Type != "about:blank" || problem.Title != "Not Found" {
+		t.Errorf("expected the status text as the title of about:blank but got %v %v", problem.Type, problem.Title)
+	}
+
+	http.SetProblemTypeBase("https://example.com/errors/")
+	defer http.SetProblemTypeBase("")
+	problem := http.NewProblem(err)
+	if problem.Type != "https://example.com/errors/missing.problem" || problem.Title != "The problem was not found." {
+		t.Errorf("expected the description as the title of a code type but got %v %v", problem.Type, problem.Title)
+	}
+}
+
+var noHTTPCode = errcode.NewCode("httpnohttp").SetNoHTTP()
+
+func TestWriteErrorNoHTTP(t *testing.T) {
+	AssertWriteError(t, noHTTPCode.Err(), 500)
+	AssertWriteError(t, errcode.Combine(noHTTPCode.Err(), errcode.NewNotFoundErr(fmt.Errorf("missing"))), 500)
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pingcap/errcode"
+)
+
+// problemTypeBase holds the base URI string set with SetProblemTypeBase.
+// It is an atomic.Value since every NewProblem reads it.
+var problemTypeBase atomic.Value
+
+// SetProblemTypeBase sets the base URI of the type of a Problem, for example "https://example.com/errors/".
+// The type is the base followed by the CodeStr.
+// Without a base the type is "about:blank" as RFC 7807 recommends.
+// This is safe to call concurrently with NewProblem.
+func SetProblemTypeBase(base string) {
+	problemTypeBase.Store(base)
+}
+
+// Problem is an RFC 7807 problem details document.
+// Code and Data are extension members with the CodeStr and the client data of the error.
+type Problem struct {
+	Type   string          `json:"type"`
+	Title  string          `json:"title"`
+	Status int             `json:"status"`
+	Detail string          `json:"detail"`
+	Code   errcode.CodeStr `json:"code"`
+	Data   interface{}     `json:"data"`
+}
+
+// NewProblem turns an ErrorCode into a Problem.
+// The title is the HTTP status text.
+// When a base is set with SetProblemTypeBase, the type is specific to the code,
+// so the Description of the code is used as the title if there is one.
+// The detail is given by errcode.UserMsg.
+// The data is the Data of errcode.NewJSONFormat, so a redacted code does not give its client data.
+func NewProblem(errCode errcode.ErrorCode) Problem {
+	code := errCode.Code()
+	status := Status(errCode)
+	typ := "about:blank"
+	title := http.StatusText(status)
+	// RFC 7807 requires the title of about:blank to be the HTTP status text
+	if base, _ := problemTypeBase.Load().(string); base != "" {
+		typ = base + code.CodeStr().String()
+		if description := code.Description(); description != "" {
+			title = description
+		}
+	}
+	return Problem{
+		Type:   typ,
+		Title:  title,
+		Status: status,
+		Detail: errcode.UserMsg(errCode),
+		Code:   code.CodeStr(),
+		Data:   errcode.NewJSONFormat(errCode).Data,
+	}
+}
+
+// WriteProblemJSON writes an ErrorCode as an RFC 7807 application/problem+json response.
+// The status and headers are the same as for WriteError.
+func WriteProblemJSON(w http.ResponseWriter, errCode errcode.ErrorCode) error {
+	return writeBody(w, errCode, "application/problem+json", NewProblem(errCode))
+}
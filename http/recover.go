@@ -0,0 +1,178 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errors"
+)
+
+// PanicMsg is the message sent to the client for a recovered panic that is not an ErrorCode.
+const PanicMsg = "internal server error"
+
+// Logger records an ErrorCode created from a recovered panic.
+// The ErrorCode includes the request (see Request) and the recovered value as its cause.
+type Logger func(r *http.Request, errCode errcode.ErrorCode)
+
+// defaultLogger logs the errcode.LogFields with the standard log package.
+func defaultLogger(r *http.Request, errCode errcode.ErrorCode) {
+	log.Printf("recovered panic: %v %v: %+v", r.Method, r.URL.Path, errcode.LogFields(errCode))
+}
+
+// Recoverer is middleware that recovers a panic from next and writes it with WriteError.
+// It uses RecovererWithLogger with a Logger that uses the standard log package.
+func Recoverer(next http.Handler) http.Handler {
+	return RecovererWithLogger(defaultLogger)(next)
+}
+
+// RecovererWithLogger gives middleware that recovers a panic and writes it with WriteError.
+// The panic is converted with errcode.GuardCode, so a stack trace is recorded.
+// A panic with an ErrorCode value keeps its code and message unless it is an internal code.
+// Any other panic or internal code gives an internal error whose message is replaced with PanicMsg:
+// the recovered value is given to the logger but is not sent to the client.
+// If the handler already wrote to the response before it panicked, the error is only logged:
+// writing it would append to a partial response.
+// A panic of http.ErrAbortHandler is not recovered.
+func RecovererWithLogger(logger Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tracked := &writeTracker{ResponseWriter: w}
+			err := errcode.GuardCode(func() error {
+				next.ServeHTTP(tracked.wrap(), r)
+				return nil
+			})
+			if err == nil {
+				return
+			}
+			if errors.Cause(err) == http.ErrAbortHandler {
+				panic(http.ErrAbortHandler)
+			}
+			errCode := err.(errcode.ErrorCode)
+			if errCode.Code().IsAncestor(errcode.InternalCode) {
+				errCode = errcode.WrapRedacted(errCode, errCode.Code(), PanicMsg)
+			}
+			logger(r, NewErrWithRequest(r, errCode))
+			if !tracked.written {
+				_ = WriteError(w, errCode)
+			}
+		})
+	}
+}
+
+// writeTracker records whether a response has been started.
+// Use wrap to give it to a handler so that the optional interfaces of the ResponseWriter are kept.
+type writeTracker struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *writeTracker) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writeTracker) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap gives the original ResponseWriter, for use with http.ResponseController.
+func (w *writeTracker) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *writeTracker) flush() {
+	w.written = true
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *writeTracker) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.written = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (w *writeTracker) push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+type flushFunc func()
+
+func (f flushFunc) Flush() { f() }
+
+type hijackFunc func() (net.Conn, *bufio.ReadWriter, error)
+
+func (f hijackFunc) Hijack() (net.Conn, *bufio.ReadWriter, error) { return f() }
+
+type pushFunc func(string, *http.PushOptions) error
+
+func (f pushFunc) Push(target string, opts *http.PushOptions) error { return f(target, opts) }
+
+// wrap gives the tracker as a ResponseWriter that also implements
+// http.Flusher, http.Hijacker, and http.Pusher when the original ResponseWriter does,
+// so that streaming and websocket upgrades still work behind Recoverer.
+func (w *writeTracker) wrap() http.ResponseWriter {
+	_, isFlusher := w.ResponseWriter.(http.Flusher)
+	_, isHijacker := w.ResponseWriter.(http.Hijacker)
+	_, isPusher := w.ResponseWriter.(http.Pusher)
+	flush, hijack, push := flushFunc(w.flush), hijackFunc(w.hijack), pushFunc(w.push)
+	switch {
+	case isFlusher && isHijacker && isPusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, flush, hijack, push}
+	case isFlusher && isHijacker:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, flush, hijack}
+	case isFlusher && isPusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{w, flush, push}
+	case isHijacker && isPusher:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, hijack, push}
+	case isFlusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{w, flush}
+	case isHijacker:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{w, hijack}
+	case isPusher:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{w, push}
+	default:
+		return w
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errors"
+)
+
+// sensitiveHeaders are never recorded in a RequestSnapshot.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// RequestSnapshot records the parts of an HTTP request that are useful for logging an error.
+// Sensitive headers such as Authorization and Cookie are removed.
+type RequestSnapshot struct {
+	Method string
+	Path   string
+	Header http.Header
+}
+
+// NewRequestSnapshot records the method, path and non-sensitive headers of a request.
+func NewRequestSnapshot(r *http.Request) RequestSnapshot {
+	header := make(http.Header, len(r.Header))
+	for key, values := range r.Header {
+		header[key] = append([]string(nil), values...)
+	}
+	for _, key := range sensitiveHeaders {
+		header.Del(key)
+	}
+	return RequestSnapshot{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: header,
+	}
+}
+
+// HasRequest is an interface to retrieve the request that was being handled during an error.
+// Generally the snapshot should be retrieved with the Request function.
+type HasRequest interface {
+	GetRequest() RequestSnapshot
+}
+
+// Request finds the first HasRequest in the Causer chain of the error.
+// The second return value is false if no request was attached.
+func Request(err error) (RequestSnapshot, bool) {
+	found := errors.Find(err, func(err error) bool {
+		_, ok := err.(HasRequest)
+		return ok
+	})
+	if found == nil {
+		return RequestSnapshot{}, false
+	}
+	return found.(HasRequest).GetRequest(), true
+}
+
+// RequestErrCode is an ErrorCode with a RequestSnapshot attached for logging.
+// The snapshot is not part of the client data.
+type RequestErrCode struct {
+	Err     errcode.ErrorCode
+	Request RequestSnapshot
+}
+
+// NewErrWithRequest attaches a snapshot of the request to an error.
+// If the error is already an ErrorCode its code is kept.
+// Otherwise it is wrapped with errcode.NewInternalErr.
+func NewErrWithRequest(r *http.Request, err error) errcode.ErrorCode {
+	errCode, ok := err.(errcode.ErrorCode)
+	if !ok {
+		errCode = errcode.NewInternalErrDepth(err, 1)
+	}
+	return RequestErrCode{Err: errCode, Request: NewRequestSnapshot(r)}
+}
+
+// GetRequest satisfies the HasRequest interface
+func (e RequestErrCode) GetRequest() RequestSnapshot {
+	return e.Request
+}
+
+// Cause satisfies the Causer interface
+func (e RequestErrCode) Cause() error {
+	return e.Err
+}
+
+// Unwrap gives Err for errors.Is and errors.As.
+func (e RequestErrCode) Unwrap() error {
+	return e.Err
+}
+
+// Error gives the underlying Err Error.
+func (e RequestErrCode) Error() string {
+	return e.Err.Error()
+}
+
+// Code returns the underlying Code of Err.
+func (e RequestErrCode) Code() errcode.Code {
+	return e.Err.Code()
+}
+
+// GetClientData returns the ClientData of the underlying Err.
+func (e RequestErrCode) GetClientData() interface{} {
+	return errcode.ClientData(e.Err)
+}
+
+var _ errcode.ErrorCode = (*RequestErrCode)(nil)     // assert implements interface
+var _ errcode.HasClientData = (*RequestErrCode)(nil) // assert implements interface
+var _ errcode.Causer = (*RequestErrCode)(nil)        // assert implements interface
+var _ HasRequest = (*RequestErrCode)(nil)            // assert implements interface
@@ -0,0 +1,134 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"golang.org/x/text/language"
+)
+
+// HasLocalizedMsg is an interface for errors that give their own user message for a language.
+// An empty string means there is no message for the language.
+// Generally the message should be retrieved with the LocalizedUserMsg function.
+type HasLocalizedMsg interface {
+	LocalizedMsg(lang language.Tag) string
+}
+
+// MsgCatalog maps a code and a language to a user message.
+// It is safe for concurrent use.
+// The catalog used by LocalizedUserMsg can be replaced with SetMsgCatalog.
+type MsgCatalog struct {
+	lock sync.RWMutex
+	msgs map[CodeStr]map[language.Tag]string
+}
+
+// NewMsgCatalog creates an empty MsgCatalog.
+func NewMsgCatalog() *MsgCatalog {
+	return &MsgCatalog{msgs: make(map[CodeStr]map[language.Tag]string)}
+}
+
+// Add sets the user message of a code for a language.
+// Returns itself.
+func (catalog *MsgCatalog) Add(code Code, lang language.Tag, msg string) *MsgCatalog {
+	catalog.lock.Lock()
+	defer catalog.lock.Unlock()
+	codeStr := code.CodeStr()
+	if catalog.msgs[codeStr] == nil {
+		catalog.msgs[codeStr] = make(map[language.Tag]string)
+	}
+	catalog.msgs[codeStr][lang] = msg
+	return catalog
+}
+
+// Msg finds the user message of a code for a language.
+// If there is none for the language, its more general parents are tried:
+// for example "fr-CA" falls back to "fr".
+// The second return value is false if no message is found.
+func (catalog *MsgCatalog) Msg(code Code, lang language.Tag) (string, bool) {
+	catalog.lock.RLock()
+	defer catalog.lock.RUnlock()
+	msgs := catalog.msgs[code.CodeStr()]
+	for {
+		if msg, ok := msgs[lang]; ok {
+			return msg, true
+		}
+		if lang.IsRoot() {
+			return "", false
+		}
+		lang = lang.Parent()
+	}
+}
+
+// Languages gives the languages that have a user message for a code, sorted by name.
+// This is useful for matching the languages a user accepts against the ones available.
+func (catalog *MsgCatalog) Languages(code Code) []language.Tag {
+	catalog.lock.RLock()
+	defer catalog.lock.RUnlock()
+	msgs := catalog.msgs[code.CodeStr()]
+	langs := make([]language.Tag, 0, len(msgs))
+	for lang := range msgs {
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool { return langs[i].String() < langs[j].String() })
+	return langs
+}
+
+var (
+	msgCatalogLock sync.RWMutex
+	msgCatalog     = NewMsgCatalog()
+)
+
+// SetMsgCatalog replaces the MsgCatalog used by LocalizedUserMsg.
+func SetMsgCatalog(catalog *MsgCatalog) {
+	msgCatalogLock.Lock()
+	defer msgCatalogLock.Unlock()
+	msgCatalog = catalog
+}
+
+// GetMsgCatalog gives the MsgCatalog used by LocalizedUserMsg so that messages can be added to it.
+func GetMsgCatalog() *MsgCatalog {
+	msgCatalogLock.RLock()
+	defer msgCatalogLock.RUnlock()
+	return msgCatalog
+}
+
+// LocalizedUserMsg gives the message that should be shown to an end user who speaks the given language.
+// It first looks for a HasLocalizedMsg in the Causer chain of the ErrorCode,
+// then in the MsgCatalog (see SetMsgCatalog) for the code.
+// Both fall back from a specific language to its base language, for example from "fr-CA" to "fr".
+// If no localized message is found, it falls back to UserMsg.
+func LocalizedUserMsg(errCode ErrorCode, lang language.Tag) string {
+	for tag := lang; ; tag = tag.Parent() {
+		var msg string
+		errors.Find(errCode, func(err error) bool {
+			if hasMsg, ok := err.(HasLocalizedMsg); ok {
+				msg = hasMsg.LocalizedMsg(tag)
+			}
+			return msg != ""
+		})
+		if msg != "" {
+			return msg
+		}
+		if tag.IsRoot() {
+			break
+		}
+	}
+	if msg, ok := GetMsgCatalog().Msg(errCode.Code(), lang); ok {
+		return msg
+	}
+	return UserMsg(errCode)
+}
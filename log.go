@@ -0,0 +1,91 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"github.com/pingcap/errors"
+)
+
+// LogField is a logging library neutral key/value pair.
+// Adapters for a particular logger can convert these to the logger's field type.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// LogFields gives the fields that should be logged for an ErrorCode.
+// These are always present:
+// * code: the CodeStr
+// * msg: the Error() string
+// * http: the HTTPStatus
+//
+// These are only present when available:
+// * cause: the Error() string of the deepest error in the Causer chain
+// * stack: the errors.StackTrace
+func LogFields(errCode ErrorCode) []LogField {
+	code := errCode.Code()
+	fields := []LogField{
+		{Key: "code", Value: code.CodeStr()},
+		{Key: "msg", Value: errCode.Error()},
+		{Key: "http", Value: code.HTTPStatus()},
+	}
+	// Only compare against a wrapped error: an ErrorCode may be a value that cannot be compared with ==
+	if unwrap(errCode) != nil {
+		if cause := errors.Cause(errCode); cause != nil {
+			fields = append(fields, LogField{Key: "cause", Value: cause.Error()})
+		}
+	}
+	if stack := StackTrace(errCode); stack != nil {
+		fields = append(fields, LogField{Key: "stack", Value: stack})
+	}
+	return fields
+}
+
+// ToFields gives a flat map of an ErrorCode for any structured logger.
+// These are always present:
+// * code: the CodeStr
+// * http_status: the HTTPStatus
+// * msg: the Error() string
+//
+// These are only present when they are not empty:
+// * grpc_code: the name of the GRPC code, when the grpc package is imported (see RegisterCatalogField)
+// * operations: the Operations of the error
+// * retryable: true when the code IsRetryable
+// * client_data: the ClientData
+func ToFields(errCode ErrorCode) map[string]interface{} {
+	code := errCode.Code()
+	fields := map[string]interface{}{
+		"code":        code.CodeStr(),
+		"http_status": code.HTTPStatus(),
+		"msg":         errCode.Error(),
+	}
+	catalogFieldsLock.RLock()
+	grpcField := catalogFields["grpc"]
+	catalogFieldsLock.RUnlock()
+	if grpcField != nil {
+		if grpcCode := grpcField(code); grpcCode != "" {
+			fields["grpc_code"] = grpcCode
+		}
+	}
+	if operations := Operations(errCode); len(operations) > 0 {
+		fields["operations"] = operations
+	}
+	if code.IsRetryable() {
+		fields["retryable"] = true
+	}
+	if data := ClientData(errCode); data != nil {
+		fields["client_data"] = data
+	}
+	return fields
+}
@@ -16,6 +16,8 @@ package errcode
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"sync"
 
 	"github.com/pingcap/errors"
 )
@@ -23,20 +25,75 @@ import (
 // MetaData is used in a pattern for attaching meta data to codes and inheriting it from a parent.
 // See MetaDataFromAncestors.
 // This is used to attach an HTTP code to a Code as meta data.
+// A MetaData should only be accessed through the methods of Code (such as SetMetaData and GetMetaData)
+// which make it safe to register codes and their meta data concurrently.
 type MetaData map[CodeStr]interface{}
 
+// metaDataLock guards all MetaData maps and httpCodeCache.
+var metaDataLock sync.RWMutex
+
+// httpCodeCache memoizes HTTPCode, which otherwise walks the ancestors of a code on every call.
+// Changing meta data with SetMetaData, SetMetaDataOverride, or ClearMetaData clears it
+// since a change to a code can affect the HTTP code of its descendants.
+var httpCodeCache = make(map[CodeStr]int)
+
+// metaDataChangedLocked must be called with metaDataLock held whenever meta data is changed.
+func metaDataChangedLocked() {
+	if len(httpCodeCache) > 0 {
+		httpCodeCache = make(map[CodeStr]int)
+	}
+}
+
+// GetMetaData looks for meta data set for the code itself.
+// Unlike MetaDataFromAncestors, ancestors are not checked.
+// The second return value is false if the meta data is not set.
+func (code Code) GetMetaData(metaData MetaData) (interface{}, bool) {
+	metaDataLock.RLock()
+	defer metaDataLock.RUnlock()
+	item, ok := metaData[code.CodeStr()]
+	return item, ok
+}
+
 // MetaDataFromAncestors looks for meta data starting at the current code.
 // If not found, it traverses up the hierarchy
 // by looking for the first ancestor with the given metadata key.
 // This is used in the HTTPCode implementation to inherit the HTTP Code from ancestors.
 func (code Code) MetaDataFromAncestors(metaData MetaData) interface{} {
+	metaDataLock.RLock()
+	defer metaDataLock.RUnlock()
+	return code.metaDataFromAncestorsLocked(metaData)
+}
+
+func (code Code) metaDataFromAncestorsLocked(metaData MetaData) interface{} {
 	if existing, ok := metaData[code.CodeStr()]; ok {
 		return existing
 	}
 	if code.Parent == nil {
 		return nil
 	}
-	return (*code.Parent).MetaDataFromAncestors(metaData)
+	return (*code.Parent).metaDataFromAncestorsLocked(metaData)
+}
+
+// MetaDataEntry is the meta data set for one code.
+type MetaDataEntry struct {
+	Code  CodeStr     `json:"code"`
+	Value interface{} `json:"value"`
+}
+
+// Entries gives the meta data set for each code, sorted by CodeStr.
+// Unlike ranging over the map, the order is stable, which keeps dumps for diagnostics and golden tests diffable.
+// Only meta data set for a code itself is given: inherited meta data is not.
+func (metaData MetaData) Entries() []MetaDataEntry {
+	metaDataLock.RLock()
+	entries := make([]MetaDataEntry, 0, len(metaData))
+	for codeStr, value := range metaData {
+		entries = append(entries, MetaDataEntry{Code: codeStr, Value: value})
+	}
+	metaDataLock.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Code < entries[j].Code
+	})
+	return entries
 }
 
 type existingCodeError struct {
@@ -51,6 +108,8 @@ func (e existingCodeError) Error() string {
 // SetMetaData is used to implement meta data setters such as SetHTTPCode.
 // Return an error if the metadata is already set.
 func (code Code) SetMetaData(metaData MetaData, item interface{}) error {
+	metaDataLock.Lock()
+	defer metaDataLock.Unlock()
 	if existingCode, ok := metaData[code.CodeStr()]; ok {
 		return existingCodeError{
 			existingMetaData: existingCode,
@@ -58,28 +117,432 @@ func (code Code) SetMetaData(metaData MetaData, item interface{}) error {
 		}
 	}
 	metaData[code.CodeStr()] = item
+	metaDataChangedLocked()
 	return nil
 }
 
+// SetMetaDataOverride sets the meta data for a code, replacing any existing value.
+// This is an escape hatch for tests and for re-configuring an existing code.
+// Normally SetMetaData should be used so that conflicting definitions are caught.
+// Returns itself.
+func (code Code) SetMetaDataOverride(metaData MetaData, item interface{}) Code {
+	metaDataLock.Lock()
+	defer metaDataLock.Unlock()
+	metaData[code.CodeStr()] = item
+	metaDataChangedLocked()
+	return code
+}
+
+// ClearMetaData removes the meta data set for the code itself.
+// The code then inherits the meta data from its ancestors again.
+// This is intended for test teardown after SetMetaDataOverride.
+// Returns itself.
+func (code Code) ClearMetaData(metaData MetaData) Code {
+	metaDataLock.Lock()
+	defer metaDataLock.Unlock()
+	delete(metaData, code.CodeStr())
+	metaDataChangedLocked()
+	return code
+}
+
 var httpMetaData = make(MetaData)
 
+type invalidHTTPCodeError struct {
+	httpCode int
+	code     CodeStr
+}
+
+func (e invalidHTTPCodeError) Error() string {
+	return fmt.Sprintf("for code %v HTTP status %v is not in the range 100-599", e.code, e.httpCode)
+}
+
+// checkHTTPCode checks that an HTTP code is a plausible HTTP status (100-599).
+func checkHTTPCode(code CodeStr, httpCode int) error {
+	if httpCode < 100 || httpCode > 599 {
+		return invalidHTTPCodeError{httpCode: httpCode, code: code}
+	}
+	return nil
+}
+
 // SetHTTP adds an HTTP code to the meta data.
 // The code can be retrieved with HTTPCode.
+// Panic if the HTTP code is not a valid status (100-599), for example a typo such as 4004.
 // Panic if the metadata is already set for the code.
 // Returns itself.
 func (code Code) SetHTTP(httpCode int) Code {
+	if err := checkHTTPCode(code.CodeStr(), httpCode); err != nil {
+		panic(errors.Annotate(err, "SetHTTP"))
+	}
 	if err := code.SetMetaData(httpMetaData, httpCode); err != nil {
 		panic(errors.Annotate(err, "SetHTTP"))
 	}
 	return code
 }
 
+// ForceSetHTTP is the same as SetHTTP but replaces an existing HTTP code rather than panicking.
+// It still panics if the HTTP code is not a valid status.
+// Returns itself.
+func (code Code) ForceSetHTTP(httpCode int) Code {
+	if err := checkHTTPCode(code.CodeStr(), httpCode); err != nil {
+		panic(errors.Annotate(err, "ForceSetHTTP"))
+	}
+	return code.SetMetaDataOverride(httpMetaData, httpCode)
+}
+
+// ResetHTTP removes the HTTP code set for the code itself.
+// HTTPCode then gives the HTTP code of an ancestor or the default.
+// Returns itself.
+func (code Code) ResetHTTP() Code {
+	return code.ClearMetaData(httpMetaData)
+}
+
+// NoHTTPCode is given by HTTPCode for a code marked with SetNoHTTP that has no HTTP code.
+const NoHTTPCode = 0
+
+var noHTTPMetaData = make(MetaData)
+
+// SetNoHTTP marks a code (and its descendants) as not meant to be sent over HTTP,
+// for example an internal-only code.
+// HTTPCode then gives NoHTTPCode rather than the default of 400 when no HTTP code is set,
+// so that a missing mapping is not silently sent as 400.
+// An HTTP code set with SetHTTP on the code or an ancestor still takes precedence.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetNoHTTP() Code {
+	if err := code.SetMetaData(noHTTPMetaData, true); err != nil {
+		panic(errors.Annotate(err, "SetNoHTTP"))
+	}
+	return code
+}
+
 // HTTPCode retrieves the HTTP code for a code or its first ancestor with an HTTP code.
-// If none are specified, it defaults to 400 BadRequest
+// If none are specified, it defaults to 400 BadRequest,
+// or to NoHTTPCode (0) if the code or an ancestor is marked with SetNoHTTP.
+// The result is cached per CodeStr until meta data is changed.
 func (code Code) HTTPCode() int {
-	httpCode := code.MetaDataFromAncestors(httpMetaData)
-	if httpCode == nil {
-		return http.StatusBadRequest
+	codeStr := code.CodeStr()
+	metaDataLock.RLock()
+	httpCode, ok := httpCodeCache[codeStr]
+	metaDataLock.RUnlock()
+	if ok {
+		return httpCode
+	}
+
+	metaDataLock.Lock()
+	defer metaDataLock.Unlock()
+	httpCode = http.StatusBadRequest
+	if found := code.metaDataFromAncestorsLocked(httpMetaData); found != nil {
+		httpCode = found.(int)
+	} else if code.metaDataFromAncestorsLocked(noHTTPMetaData) != nil {
+		httpCode = NoHTTPCode
+	}
+	httpCodeCache[codeStr] = httpCode
+	return httpCode
+}
+
+// HTTPStatus gives the HTTP status to send in a response for the code.
+// This is the HTTPCode, except that a code without an HTTP code (NoHTTPCode, see SetNoHTTP)
+// was not meant to be sent over HTTP, so it is sent as 500.
+func (code Code) HTTPStatus() int {
+	if httpCode := code.HTTPCode(); httpCode != NoHTTPCode {
+		return httpCode
+	}
+	return http.StatusInternalServerError
+}
+
+// FirstExplicitHTTPCode resolves the HTTP code of an error from its Causer chain
+// rather than from the ancestors of a single code.
+// The chain is walked from the outermost error, and the first ErrorCode
+// whose code has an HTTP code set for itself (see HasExplicitHTTP) wins.
+// So an inner NotFound error (404) wins over an outer code that would only inherit or default to 400.
+// In contrast HTTPCode only considers the ancestors of one code.
+// If no code in the chain sets an HTTP code, the HTTPCode of the outermost ErrorCode is used.
+// An error without any ErrorCode gives 500.
+func FirstExplicitHTTPCode(err error) int {
+	var outer ErrorCode
+	found := errors.Find(err, func(err error) bool {
+		errCode, ok := err.(ErrorCode)
+		if !ok {
+			return false
+		}
+		if outer == nil {
+			outer = errCode
+		}
+		return errCode.Code().HasExplicitHTTP()
+	})
+	if found != nil {
+		return found.(ErrorCode).Code().HTTPCode()
+	}
+	if outer != nil {
+		return outer.Code().HTTPCode()
+	}
+	return http.StatusInternalServerError
+}
+
+// HasExplicitHTTP tells whether an HTTP code was set for the code itself
+// rather than inherited from an ancestor or defaulted.
+func (code Code) HasExplicitHTTP() bool {
+	_, ok := code.GetMetaData(httpMetaData)
+	return ok
+}
+
+var httpUnprocessableMetaData = make(MetaData)
+
+// SetHTTPUnprocessable registers 422 Unprocessable Entity as a secondary HTTP code.
+// This is for a code (such as a validation error) that is normally sent with HTTPCode
+// but that some handlers send as 422 when the request is well formed but semantically invalid.
+// The secondary code is only used by callers that opt in with HTTPCodeUnprocessable.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetHTTPUnprocessable() Code {
+	if err := code.SetMetaData(httpUnprocessableMetaData, http.StatusUnprocessableEntity); err != nil {
+		panic(errors.Annotate(err, "SetHTTPUnprocessable"))
+	}
+	return code
+}
+
+// HTTPCodeUnprocessable resolves the HTTP code for a caller that opts into the secondary mapping.
+// The code and then its ancestors are checked in order, and the first of these to apply is used:
+//   - the secondary code if it is marked with SetHTTPUnprocessable
+//   - the HTTPCode if it has an explicit HTTP code (see HasExplicitHTTP)
+//
+// So a descendant that sets its own HTTP code with SetHTTP is not sent as 422
+// because an ancestor is marked with SetHTTPUnprocessable.
+// If neither applies to any of them, the HTTPCode is used.
+//
+// HTTPCode itself always gives the primary mapping.
+func (code Code) HTTPCodeUnprocessable() int {
+	for _, ancestor := range code.Ancestors() {
+		if httpCode, ok := ancestor.GetMetaData(httpUnprocessableMetaData); ok {
+			return httpCode.(int)
+		}
+		if ancestor.HasExplicitHTTP() {
+			break
+		}
 	}
-	return httpCode.(int)
+	return code.HTTPCode()
+}
+
+// IsClientError tells whether the HTTPStatus of the code is a client error (4xx).
+// A code without an HTTP code defaults to 400 and so is a client error,
+// unless it is marked with SetNoHTTP.
+func (code Code) IsClientError() bool {
+	httpCode := code.HTTPStatus()
+	return httpCode >= http.StatusBadRequest && httpCode < http.StatusInternalServerError
+}
+
+// IsServerError tells whether the HTTPStatus of the code is a server error (5xx).
+// A code marked with SetNoHTTP is sent as 500 and so is a server error.
+// This is useful for deciding whether an error should alert.
+func (code Code) IsServerError() bool {
+	httpCode := code.HTTPStatus()
+	return httpCode >= http.StatusInternalServerError && httpCode < 600
+}
+
+// IsClientError is a convenience for checking whether the Code of an ErrorCode is a client error.
+func IsClientError(errCode ErrorCode) bool {
+	return errCode.Code().IsClientError()
+}
+
+// IsServerError is a convenience for checking whether the Code of an ErrorCode is a server error.
+func IsServerError(errCode ErrorCode) bool {
+	return errCode.Code().IsServerError()
+}
+
+var noCacheMetaData = make(MetaData)
+
+// SetNoCache marks whether responses for a code must not be cached.
+// This is intended for transient errors where a cached response would be stale.
+// The value can be retrieved with NoCache.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetNoCache(noCache bool) Code {
+	if err := code.SetMetaData(noCacheMetaData, noCache); err != nil {
+		panic(errors.Annotate(err, "SetNoCache"))
+	}
+	return code
+}
+
+// NoCache retrieves the no-cache flag for a code or its first ancestor with the flag set.
+// If none are specified, it defaults to false.
+func (code Code) NoCache() bool {
+	noCache := code.MetaDataFromAncestors(noCacheMetaData)
+	if noCache == nil {
+		return false
+	}
+	return noCache.(bool)
+}
+
+var retryableMetaData = make(MetaData)
+
+// SetRetryable marks whether an operation that failed with this code is safe to retry.
+// The value can be retrieved with IsRetryable.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetRetryable(retryable bool) Code {
+	if err := code.SetMetaData(retryableMetaData, retryable); err != nil {
+		panic(errors.Annotate(err, "SetRetryable"))
+	}
+	return code
+}
+
+// IsRetryable retrieves the retryable flag for a code or its first ancestor with the flag set.
+// If none are specified, it defaults to false.
+func (code Code) IsRetryable() bool {
+	retryable := code.MetaDataFromAncestors(retryableMetaData)
+	if retryable == nil {
+		return false
+	}
+	return retryable.(bool)
+}
+
+// IsRetryable is a convenience for checking the retryable flag of the Code of an ErrorCode.
+func IsRetryable(errCode ErrorCode) bool {
+	return errCode.Code().IsRetryable()
+}
+
+var slaImpactingMetaData = make(MetaData)
+
+// SetSLAImpacting adds a flag to the meta data of a code indicating whether it counts against SLOs.
+// The flag can be retrieved with IsSLAImpacting.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetSLAImpacting(impacting bool) Code {
+	if err := code.SetMetaData(slaImpactingMetaData, impacting); err != nil {
+		panic(errors.Annotate(err, "SetSLAImpacting"))
+	}
+	return code
+}
+
+// IsSLAImpacting retrieves the SLA impacting flag for a code or its first ancestor with the flag set.
+// If none are specified, server errors (an HTTPStatus of 5xx) are SLA impacting and all others are not.
+func (code Code) IsSLAImpacting() bool {
+	impacting := code.MetaDataFromAncestors(slaImpactingMetaData)
+	if impacting == nil {
+		return code.HTTPStatus() >= http.StatusInternalServerError
+	}
+	return impacting.(bool)
+}
+
+// IsSLAImpacting tells whether an error counts against SLOs.
+// The code is found with CodeChain.
+// An error without a code is treated as an internal error and so is SLA impacting.
+// A nil error is not SLA impacting.
+func IsSLAImpacting(err error) bool {
+	if err == nil {
+		return false
+	}
+	errCode := CodeChain(err)
+	if errCode == nil {
+		return true
+	}
+	return errCode.Code().IsSLAImpacting()
+}
+
+var severityMetaData = make(MetaData)
+
+// SetSeverity adds a Severity to the meta data of a code.
+// The Severity can be used to choose a log level.
+// It can be retrieved with Severity.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetSeverity(severity Severity) Code {
+	if err := code.SetMetaData(severityMetaData, severity); err != nil {
+		panic(errors.Annotate(err, "SetSeverity"))
+	}
+	return code
+}
+
+// Severity retrieves the Severity for a code or its first ancestor with a Severity set.
+// If none are specified, it is SeverityError for server errors (an HTTPStatus of 5xx) and SeverityInfo otherwise.
+func (code Code) Severity() Severity {
+	severity := code.MetaDataFromAncestors(severityMetaData)
+	if severity != nil {
+		return severity.(Severity)
+	}
+	if code.HTTPStatus() >= http.StatusInternalServerError {
+		return SeverityError
+	}
+	return SeverityInfo
+}
+
+var deprecatedMetaData = make(MetaData)
+
+// Deprecate marks a code as deprecated in favor of a replacement code.
+// A deprecated code still works as before, but documentation and linters can warn about its use.
+// The replacement can be retrieved with Replacement.
+// Panic if the replacement is the code itself, since Replacement would then loop back to the deprecated code.
+// Panic if the code is already deprecated.
+// Returns itself.
+func (code Code) Deprecate(replacement Code) Code {
+	if replacement.Equal(code) {
+		panic(errors.Annotate(fmt.Errorf("code %v cannot be its own replacement", code.CodeStr()), "Deprecate"))
+	}
+	if err := code.SetMetaData(deprecatedMetaData, replacement); err != nil {
+		panic(errors.Annotate(err, "Deprecate"))
+	}
+	return code
+}
+
+// IsDeprecated tells whether Deprecate was called for the code.
+// Deprecation is not inherited from ancestors.
+func (code Code) IsDeprecated() bool {
+	_, ok := code.GetMetaData(deprecatedMetaData)
+	return ok
+}
+
+// Replacement gives the code that replaces a deprecated code.
+// The second return value is false if the code is not deprecated.
+func (code Code) Replacement() (Code, bool) {
+	replacement, ok := code.GetMetaData(deprecatedMetaData)
+	if !ok {
+		return Code{}, false
+	}
+	return replacement.(Code), true
+}
+
+var descriptionMetaData = make(MetaData)
+
+// SetDescription adds a human readable description of a code to the meta data.
+// This is used for documentation, for example in ExportCatalog.
+// The description can be retrieved with Description.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetDescription(description string) Code {
+	if err := code.SetMetaData(descriptionMetaData, description); err != nil {
+		panic(errors.Annotate(err, "SetDescription"))
+	}
+	return code
+}
+
+// Description retrieves the description of a code.
+// Descriptions are not inherited from ancestors: each code documents itself.
+// If none was set for the code it returns an empty string.
+func (code Code) Description() string {
+	description, _ := code.GetMetaData(descriptionMetaData)
+	if description == nil {
+		return ""
+	}
+	return description.(string)
+}
+
+var exampleMetaData = make(MetaData)
+
+// SetExample adds an example of the client data for a code to the meta data.
+// This is used for documentation, for example in ExportCatalog.
+// The example can be retrieved with Example.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetExample(example interface{}) Code {
+	if err := code.SetMetaData(exampleMetaData, example); err != nil {
+		panic(errors.Annotate(err, "SetExample"))
+	}
+	return code
+}
+
+// Example retrieves the example client data for a code.
+// Examples are not inherited from ancestors: if none was set for the code it returns nil.
+func (code Code) Example() interface{} {
+	example, _ := code.GetMetaData(exampleMetaData)
+	return example
 }
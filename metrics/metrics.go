@@ -0,0 +1,69 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics counts error codes with Prometheus.
+// It is kept separate from the errcode package so that errcode does not depend on Prometheus.
+//
+// Register the Collector and call IncErrorCode wherever errors are sent to a client:
+//
+//	prometheus.MustRegister(metrics.Collector())
+//	metrics.IncErrorCode(errCode)
+package metrics
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/pingcap/errcode"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels are the label names of the counter: the CodeStr and the HTTP status.
+var Labels = []string{"code", "http"}
+
+// NewCounterVec creates a CounterVec named errcode_errors_total with the Labels.
+// Use this with SetCounter to count into a counter registered with your own registry.
+func NewCounterVec() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "errcode_errors_total",
+		Help: "Number of errors by error code and HTTP status.",
+	}, Labels)
+}
+
+var (
+	counterLock sync.RWMutex
+	counter     = NewCounterVec()
+)
+
+// SetCounter replaces the counter used by IncErrorCode.
+// The counter must have the Labels.
+func SetCounter(counterVec *prometheus.CounterVec) {
+	counterLock.Lock()
+	defer counterLock.Unlock()
+	counter = counterVec
+}
+
+// Collector gives the counter used by IncErrorCode so that it can be registered.
+func Collector() prometheus.Collector {
+	counterLock.RLock()
+	defer counterLock.RUnlock()
+	return counter
+}
+
+// IncErrorCode increments the counter for the code and HTTP status of an ErrorCode.
+func IncErrorCode(errCode errcode.ErrorCode) {
+	code := errCode.Code()
+	counterLock.RLock()
+	defer counterLock.RUnlock()
+	counter.WithLabelValues(code.CodeStr().String(), strconv.Itoa(code.HTTPStatus())).Inc()
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errcode/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIncErrorCode(t *testing.T) {
+	counter := metrics.NewCounterVec()
+	metrics.SetCounter(counter)
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(metrics.Collector()); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics.IncErrorCode(errcode.NewNotFoundErr(fmt.Errorf("missing")))
+	metrics.IncErrorCode(errcode.NewNotFoundErr(fmt.Errorf("missing again")))
+	metrics.IncErrorCode(errcode.NewInternalErr(fmt.Errorf("internal")))
+
+	AssertCount(t, counter, 2, "missing", "404")
+	AssertCount(t, counter, 1, "internal", "500")
+	if got := testutil.CollectAndCount(registry); got != 2 {
+		t.Errorf("expected 2 series but got %v", got)
+	}
+}
+
+func AssertCount(t *testing.T, counter *prometheus.CounterVec, expected float64, labels ...string) {
+	t.Helper()
+	if got := testutil.ToFloat64(counter.WithLabelValues(labels...)); got != expected {
+		t.Errorf("expected count %v for %v but got %v", expected, labels, got)
+	}
+}
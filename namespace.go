@@ -0,0 +1,101 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import "sync"
+
+// Namespace prefixes the codes of a service so that services sharing this package do not collide.
+// Codes created with a Namespace are registered under the prefix:
+// the code "internal" of the "billing" namespace is registered as "billing.internal".
+//
+// The prefix is itself a top-level code that is the root of all codes in the namespace,
+// so IsAncestor and InFamily can check whether a code belongs to the namespace.
+// Children of a namespaced code are created with Child as usual, using the full CodeStr.
+//
+// A code created with NewCode has only the prefix as an ancestor, so it does not inherit anything from the standard codes:
+// unless set on it, it is sent as HTTP 400, is not retryable, and has the GRPC code Unknown.
+// Use Child to namespace a code within a standard family such as InternalCode instead.
+type Namespace struct {
+	root Code
+}
+
+// NewNamespace creates a namespace with the given prefix.
+// The prefix is created with NewCode, so it must be a valid top-level code that was not already created:
+// otherwise it will panic.
+func NewNamespace(prefix CodeStr) Namespace {
+	return Namespace{root: NewCode(prefix)}
+}
+
+// Prefix gives the prefix of the namespace.
+func (ns Namespace) Prefix() CodeStr {
+	return ns.root.CodeStr()
+}
+
+// Code gives the code of the prefix, which is the ancestor of all codes in the namespace.
+func (ns Namespace) Code() Code {
+	return ns.root
+}
+
+// NewCode creates a new top-level code in the namespace.
+// The code is registered with the namespace prefix.
+// Like the package-level NewCode, the code must not contain any dot separators
+// and must not already be created: otherwise it will panic.
+func (ns Namespace) NewCode(codeRep CodeStr) Code {
+	return ns.root.Child(ns.prefixed(codeRep))
+}
+
+// namespaceLock guards the lazy creation of the family codes of namespaces in Child.
+var namespaceLock sync.Mutex
+
+// Child creates a code of the namespace within a family of codes, such as InternalCode,
+// so that it inherits the meta data of the family: its HTTP code, retryability, GRPC code, and Category.
+// The code is registered under the family as the family CodeStr, the prefix, and then codeRep:
+// in the "billing" namespace, Child(InternalCode, "db") is "internal.billing.db".
+// The code "internal.billing" is created the first time it is needed.
+//
+// Unlike a code created with NewCode, the code is not a descendant of the prefix code
+// and is not found by Lookup or Codes.
+// Like NewCode, codeRep must not contain any dot separators
+// and the code must not already be created: otherwise it will panic.
+func (ns Namespace) Child(family Code, codeRep CodeStr) Code {
+	parent := ns.familyCode(family)
+	return parent.Child(parent.CodeStr() + "." + codeRep)
+}
+
+// familyCode gives the code of the namespace within a family, creating it if needed.
+func (ns Namespace) familyCode(family Code) Code {
+	namespaceLock.Lock()
+	defer namespaceLock.Unlock()
+	codeStr := family.CodeStr() + "." + ns.Prefix()
+	if code, ok := LookupCode(codeStr); ok {
+		return code
+	}
+	return family.Child(codeStr)
+}
+
+// Lookup finds a code registered in the namespace by its CodeStr without the namespace prefix.
+// The second return value is false if no code is registered for the CodeStr in the namespace.
+func (ns Namespace) Lookup(codeStr CodeStr) (Code, bool) {
+	return LookupCode(ns.prefixed(codeStr))
+}
+
+// Codes gives the codes registered in the namespace, sorted by CodeStr.
+// The namespace prefix code itself is not included.
+func (ns Namespace) Codes() []Code {
+	return ns.root.Descendants()
+}
+
+func (ns Namespace) prefixed(codeStr CodeStr) CodeStr {
+	return ns.Prefix() + "." + codeStr
+}
@@ -61,6 +61,11 @@ func (e OpErrCode) Cause() error {
 	return e.Err
 }
 
+// Unwrap gives Err for errors.Is and errors.As.
+func (e OpErrCode) Unwrap() error {
+	return e.Err
+}
+
 // Error prefixes the operation to the underlying Err Error.
 func (e OpErrCode) Error() string {
 	return e.Operation + ": " + e.Err.Error()
@@ -81,6 +86,9 @@ func (e OpErrCode) GetClientData() interface{} {
 	return ClientData(e.Err)
 }
 
+// keepsClientData marks that the client data is the client data of the underlying Err.
+func (e OpErrCode) keepsClientData() {}
+
 var _ ErrorCode = (*OpErrCode)(nil)     // assert implements interface
 var _ HasClientData = (*OpErrCode)(nil) // assert implements interface
 var _ HasOperation = (*OpErrCode)(nil)  // assert implements interface
@@ -110,3 +118,24 @@ func Op(operation string) AddOp {
 		return OpErrCode{Operation: operation, Err: err}
 	}
 }
+
+// WithOperation adds an operation to an ErrorCode.
+// This is the same as Op(operation).AddTo(err).
+// The Code, Cause, and client data of the ErrorCode are unchanged.
+func WithOperation(err ErrorCode, operation string) ErrorCode {
+	return Op(operation).AddTo(err)
+}
+
+// Operations gives all of the operations found in the Causer or Unwrap chain of the error.
+// The outermost operation (the last one added) is first.
+// This can be used to show a breadcrumb trail of operations in logs.
+func Operations(err error) []string {
+	var operations []string
+	for err != nil {
+		if op := Operation(err); op != "" {
+			operations = append(operations, op)
+		}
+		err = unwrap(err)
+	}
+	return operations
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"github.com/pingcap/errors"
+)
+
+// HasOriginService is an interface to retrieve the name of the service that first produced an error.
+// Generally the name should be retrieved with the OriginService function.
+type HasOriginService interface {
+	GetOriginService() string
+}
+
+// OriginService finds the first HasOriginService in the Causer chain of the error.
+// It returns an empty string if there is no origin service.
+func OriginService(err error) string {
+	found := errors.Find(err, func(err error) bool {
+		_, ok := err.(HasOriginService)
+		return ok
+	})
+	if found == nil {
+		return ""
+	}
+	return found.(HasOriginService).GetOriginService()
+}
+
+// OriginErrCode is an ErrorCode with the name of the service that first produced it.
+// This can be constructed with WithOriginService.
+type OriginErrCode struct {
+	wrappedErrCode
+	Service string
+}
+
+// WithOriginService records the name of the service that produced an error.
+// As an error is passed between services, the first service wins:
+// if the error already has an origin service it is returned unchanged.
+// The origin is sent to the client: see OriginClientData.
+func WithOriginService(err ErrorCode, service string) ErrorCode {
+	if err == nil {
+		panic("WithOriginService error is nil")
+	}
+	if OriginService(err) != "" {
+		return err
+	}
+	return OriginErrCode{wrappedErrCode: wrappedErrCode{err}, Service: service}
+}
+
+// OriginClientData is the client data of an OriginErrCode.
+// Data is the ClientData of the wrapped error.
+type OriginClientData struct {
+	Data   interface{} `json:"data"`
+	Origin string      `json:"origin"`
+}
+
+// GetOriginService satisfies the HasOriginService interface
+func (e OriginErrCode) GetOriginService() string {
+	return e.Service
+}
+
+// GetClientData returns OriginClientData.
+func (e OriginErrCode) GetClientData() interface{} {
+	return OriginClientData{Data: ClientData(e.Err), Origin: e.Service}
+}
+
+var _ ErrorCode = (*OriginErrCode)(nil)        // assert implements interface
+var _ HasClientData = (*OriginErrCode)(nil)    // assert implements interface
+var _ HasOriginService = (*OriginErrCode)(nil) // assert implements interface
@@ -0,0 +1,16 @@
+module github.com/pingcap/errcode/otel
+
+go 1.18
+
+require (
+	github.com/pingcap/errcode v0.0.0
+	go.opentelemetry.io/otel v1.11.2
+	go.opentelemetry.io/otel/trace v1.11.2
+)
+
+require (
+	github.com/pingcap/errors v0.10.1 // indirect
+	golang.org/x/text v0.7.0 // indirect
+)
+
+replace github.com/pingcap/errcode => ../
@@ -0,0 +1,97 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otel records error codes on OpenTelemetry spans.
+// It is kept separate from the errcode package so that errcode does not depend on OpenTelemetry.
+//
+// An error marked with errcode.WithForceSample cannot change the sampling decision of the span it is recorded on:
+// OpenTelemetry decides sampling when a span starts and the sampled flag of a started span is immutable.
+// RecordError instead adds the ForceSampleKey attribute, which a tail sampler can use to keep the trace,
+// and ContextWithForceSample marks the spans started after the error as sampled for a parent based sampler.
+// This package only depends on the OpenTelemetry API, so it does not provide an SDK Sampler or SpanProcessor.
+package otel
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pingcap/errcode"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The attribute keys set by RecordError.
+const (
+	CodeKey        = attribute.Key("errcode.code")
+	HTTPStatusKey  = attribute.Key("http.status_code")
+	ForceSampleKey = attribute.Key("errcode.force_sample")
+)
+
+// Recorder records error codes on spans.
+// The zero value only sets an Error status for server errors (HTTP 5xx).
+type Recorder struct {
+	// ClientErrors also sets an Error status for client errors (HTTP 4xx).
+	// Otherwise the status of the span is left Unset for them.
+	ClientErrors bool
+}
+
+// RecordError records an ErrorCode on a span with the zero value Recorder.
+func RecordError(span trace.Span, errCode errcode.ErrorCode) {
+	Recorder{}.RecordError(span, errCode)
+}
+
+// RecordError records an ErrorCode on a span.
+// The error is recorded as an event and the span is given attributes for the code and HTTP status.
+// The span status is set to Error with the CodeStr as the description
+// for server errors, and for client errors if ClientErrors is set.
+//
+// An error marked with errcode.WithForceSample always sets an Error status
+// and is given the ForceSampleKey attribute so that a tail sampler can keep the trace.
+func (recorder Recorder) RecordError(span trace.Span, errCode errcode.ErrorCode) {
+	code := errCode.Code()
+	httpCode := code.HTTPStatus()
+	attributes := []attribute.KeyValue{
+		CodeKey.String(code.CodeStr().String()),
+		HTTPStatusKey.Int(httpCode),
+	}
+	forceSample := errcode.IsForceSample(errCode)
+	if forceSample {
+		attributes = append(attributes, ForceSampleKey.Bool(true))
+	}
+	span.RecordError(errCode)
+	span.SetAttributes(attributes...)
+
+	isError := httpCode >= http.StatusInternalServerError ||
+		(recorder.ClientErrors && httpCode >= http.StatusBadRequest)
+	if isError || forceSample {
+		span.SetStatus(codes.Error, code.CodeStr().String())
+	}
+}
+
+// ContextWithForceSample gives a context whose span context has the sampled flag set
+// if the error is marked with errcode.WithForceSample.
+// Otherwise, or if the context has no valid span context, the context is returned unchanged.
+// Spans started from the returned context are sampled by a parent based sampler,
+// so the work done after an important failure (a retry or a call to another service) is traced.
+// The returned context only carries the span context: use the original context to end its span.
+func ContextWithForceSample(ctx context.Context, errCode errcode.ErrorCode) context.Context {
+	if !errcode.IsForceSample(errCode) {
+		return ctx
+	}
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() || spanContext.IsSampled() {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, spanContext.WithTraceFlags(spanContext.TraceFlags().WithSampled(true)))
+}
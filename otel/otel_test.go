@@ -0,0 +1,115 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otel_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pingcap/errcode"
+	"github.com/pingcap/errcode/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanStub records what is set on a span
+type spanStub struct {
+	trace.Span
+	errs        []error
+	attributes  []attribute.KeyValue
+	status      codes.Code
+	description string
+}
+
+func newSpanStub() *spanStub {
+	return &spanStub{Span: trace.SpanFromContext(context.Background())}
+}
+
+func (span *spanStub) RecordError(err error, options ...trace.EventOption) {
+	span.errs = append(span.errs, err)
+}
+
+func (span *spanStub) SetAttributes(attributes ...attribute.KeyValue) {
+	span.attributes = append(span.attributes, attributes...)
+}
+
+func (span *spanStub) SetStatus(code codes.Code, description string) {
+	span.status = code
+	span.description = description
+}
+
+func TestRecordError(t *testing.T) {
+	internal := errcode.NewInternalErr(fmt.Errorf("internal"))
+	span := newSpanStub()
+	otel.RecordError(span, internal)
+	AssertSpan(t, span, codes.Error, "internal",
+		otel.CodeKey.String("internal"), otel.HTTPStatusKey.Int(500))
+	if len(span.errs) != 1 || span.errs[0] != internal {
+		t.Errorf("expected the error to be recorded, got %v", span.errs)
+	}
+
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+	span = newSpanStub()
+	otel.RecordError(span, notFound)
+	AssertSpan(t, span, codes.Unset, "",
+		otel.CodeKey.String("missing"), otel.HTTPStatusKey.Int(404))
+
+	span = newSpanStub()
+	otel.Recorder{ClientErrors: true}.RecordError(span, notFound)
+	AssertSpan(t, span, codes.Error, "missing",
+		otel.CodeKey.String("missing"), otel.HTTPStatusKey.Int(404))
+
+	span = newSpanStub()
+	otel.RecordError(span, errcode.WithForceSample(notFound))
+	AssertSpan(t, span, codes.Error, "missing",
+		otel.CodeKey.String("missing"), otel.HTTPStatusKey.Int(404), otel.ForceSampleKey.Bool(true))
+}
+
+func TestContextWithForceSample(t *testing.T) {
+	unsampled := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{1},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), unsampled)
+	notFound := errcode.NewNotFoundErr(fmt.Errorf("missing"))
+
+	sampled := trace.SpanContextFromContext(otel.ContextWithForceSample(ctx, errcode.WithForceSample(notFound)))
+	if !sampled.IsSampled() {
+		t.Errorf("expected a force sampled error to mark the span context sampled")
+	}
+	if sampled.TraceID() != unsampled.TraceID() || sampled.SpanID() != unsampled.SpanID() {
+		t.Errorf("expected the same span but got %v", sampled)
+	}
+
+	if otel.ContextWithForceSample(ctx, notFound) != ctx {
+		t.Errorf("expected the context to be unchanged for an error that is not force sampled")
+	}
+	background := context.Background()
+	if otel.ContextWithForceSample(background, errcode.WithForceSample(notFound)) != background {
+		t.Errorf("expected the context to be unchanged without a span context")
+	}
+}
+
+func AssertSpan(t *testing.T, span *spanStub, status codes.Code, description string, attributes ...attribute.KeyValue) {
+	t.Helper()
+	if span.status != status || span.description != description {
+		t.Errorf("expected status %v %q but got %v %q", status, description, span.status, span.description)
+	}
+	if !reflect.DeepEqual(span.attributes, attributes) {
+		t.Errorf("expected attributes %v but got %v", attributes, span.attributes)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+// RangeBounds is the client data of an OutOfRangeCode error created by NewOutOfRangeErr.
+// A nil Min or Max is an open bound and is left out of the client data.
+type RangeBounds struct {
+	Min    interface{} `json:"min,omitempty"`
+	Max    interface{} `json:"max,omitempty"`
+	Actual interface{} `json:"actual"`
+}
+
+// outOfRangeErr gives the code OutOfRangeCode.
+// The RangeBounds are sent to the client.
+type outOfRangeErr struct {
+	CodedError
+	bounds RangeBounds
+}
+
+// NewOutOfRangeErr creates an outOfRangeErr from an err.
+// The expected bounds and the actual value (for example of a pagination offset) are given in the client data.
+// If the error is already an ErrorCode it will use that code.
+// Otherwise it will use OutOfRangeCode which gives HTTP 400.
+func NewOutOfRangeErr(err error, min, max, actual interface{}) ErrorCode {
+	return outOfRangeErr{
+		CodedError: NewCodedError(err, OutOfRangeCode),
+		bounds:     RangeBounds{Min: min, Max: max, Actual: actual},
+	}
+}
+
+// GetClientData returns the RangeBounds.
+func (e outOfRangeErr) GetClientData() interface{} {
+	return e.bounds
+}
+
+var _ ErrorCode = (*outOfRangeErr)(nil)     // assert implements interface
+var _ HasClientData = (*outOfRangeErr)(nil) // assert implements interface
+var _ Causer = (*outOfRangeErr)(nil)        // assert implements interface
@@ -0,0 +1,57 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"fmt"
+)
+
+// RecodedErrCode gives an ErrorCode a more specific code.
+// This can be constructed with WithCode.
+type RecodedErrCode struct {
+	wrappedErrCode
+	GetCode Code
+}
+
+// WithCode re-codes an error with a more specific code, for example
+// a broad InvalidInputCode error caught from a library to a child such as input.email.invalid.
+// The error message, client data, and stack trace of the original error are kept.
+//
+// The new code must be the code of the error or one of its descendants:
+// otherwise it panics to keep the code hierarchy consistent.
+func WithCode(err ErrorCode, newCode Code) ErrorCode {
+	if err == nil {
+		panic("WithCode error is nil")
+	}
+	if !newCode.IsAncestor(err.Code()) {
+		panic(fmt.Sprintf("WithCode %v is not a descendant of %v", newCode.CodeStr(), err.Code().CodeStr()))
+	}
+	return RecodedErrCode{wrappedErrCode: wrappedErrCode{err}, GetCode: newCode}
+}
+
+// Code returns the GetCode field
+func (e RecodedErrCode) Code() Code {
+	return e.GetCode
+}
+
+// Is matches a sentinel error with the new code for errors.Is.
+// The sentinels of the original code are matched through Unwrap.
+func (e RecodedErrCode) Is(target error) bool {
+	return isSentinel(e.GetCode, target)
+}
+
+// keepsClientData marks that the client data is the client data of the underlying Err.
+func (e RecodedErrCode) keepsClientData() {}
+
+var _ ErrorCode = (*RecodedErrCode)(nil) // assert implements interface
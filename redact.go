@@ -0,0 +1,118 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"net/http"
+
+	"github.com/pingcap/errors"
+)
+
+// RedactedErrCode attaches a code to an error from a trusted layer
+// while replacing its message with one that is safe to show to clients.
+// Error() gives SafeMsg. The original error is only reachable through Cause for logging.
+// No client data is given.
+type RedactedErrCode struct {
+	Err     error
+	GetCode Code
+	SafeMsg string
+}
+
+// WrapRedacted creates a RedactedErrCode.
+// Use this at a trust boundary so that the message of err is never sent to a client.
+// Panics if err is nil.
+func WrapRedacted(err error, code Code, safeMsg string) ErrorCode {
+	if err == nil {
+		panic("WrapRedacted given a nil error")
+	}
+	return RedactedErrCode{Err: err, GetCode: code, SafeMsg: safeMsg}
+}
+
+// Error gives the safe message rather than the message of the original error.
+func (e RedactedErrCode) Error() string {
+	return e.SafeMsg
+}
+
+// Code returns the GetCode field
+func (e RedactedErrCode) Code() Code {
+	return e.GetCode
+}
+
+// Cause satisfies the Causer interface.
+// The original error should only be used for logging.
+func (e RedactedErrCode) Cause() error {
+	return e.Err
+}
+
+// Unwrap gives the original error for errors.Is and errors.As.
+func (e RedactedErrCode) Unwrap() error {
+	return e.Err
+}
+
+// Is matches a sentinel error with the code, such as ErrInternal, for errors.Is.
+func (e RedactedErrCode) Is(target error) bool {
+	return isSentinel(e.GetCode, target)
+}
+
+// GetClientData returns nil so that no data from the original error reaches the client.
+func (e RedactedErrCode) GetClientData() interface{} {
+	return nil
+}
+
+// GetUserMsg gives the safe message, so that it is used rather than the user message of the original error.
+func (e RedactedErrCode) GetUserMsg() string {
+	return e.SafeMsg
+}
+
+var _ ErrorCode = (*RedactedErrCode)(nil)     // assert implements interface
+var _ HasClientData = (*RedactedErrCode)(nil) // assert implements interface
+var _ HasUserMsg = (*RedactedErrCode)(nil)    // assert implements interface
+var _ Causer = (*RedactedErrCode)(nil)        // assert implements interface
+
+var redactedMetaData = make(MetaData)
+
+// SetRedacted marks whether the client data of errors with a code is withheld from responses.
+// Descendants inherit the flag, so a whole family of sensitive codes can be redacted at once.
+// The flag can be retrieved with IsRedacted.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetRedacted(redacted bool) Code {
+	if err := code.SetMetaData(redactedMetaData, redacted); err != nil {
+		panic(errors.Annotate(err, "SetRedacted"))
+	}
+	return code
+}
+
+// IsRedacted retrieves the redacted flag for a code or its first ancestor with the flag set.
+// If none are specified, it defaults to false.
+func (code Code) IsRedacted() bool {
+	redacted := code.MetaDataFromAncestors(redactedMetaData)
+	if redacted == nil {
+		return false
+	}
+	return redacted.(bool)
+}
+
+// RedactedClientData is the placeholder sent instead of the client data of a redacted code (see SetRedacted).
+// Msg is the generic HTTP status text.
+// ClientData is unchanged so that the full detail is still available for logging.
+type RedactedClientData struct {
+	Code     CodeStr `json:"code"`
+	Msg      string  `json:"msg"`
+	Redacted bool    `json:"redacted"`
+}
+
+func redactedClientData(code Code) RedactedClientData {
+	return RedactedClientData{Code: code.CodeStr(), Msg: http.StatusText(code.HTTPStatus()), Redacted: true}
+}
@@ -0,0 +1,177 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry records codes by their full CodeStr.
+// The codes created with NewCode or Child are recorded in a default Registry (see RegisteredCodes).
+// A separate Registry can be used to collect the codes of a particular service,
+// for example to Merge the codes of services that are being combined.
+// Meta data is attached to a code rather than a Registry, so it is shared by all registries.
+// A Registry is safe for concurrent use.
+type Registry struct {
+	lock  sync.RWMutex
+	codes map[CodeStr]Code
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{codes: make(map[CodeStr]Code)}
+}
+
+// registry records every code created with NewCode or Child.
+// Codes are normally created once as package variables,
+// so running tests multiple times in the same process does not register a code twice.
+var registry = NewRegistry()
+
+type duplicateCodeError struct {
+	codeStr CodeStr
+}
+
+func (e duplicateCodeError) Error() string {
+	return fmt.Sprintf("code is already registered: %v", e.codeStr)
+}
+
+// Register adds a code to the Registry.
+// Return an error if a code with the same CodeStr is already registered.
+func (r *Registry) Register(code Code) error {
+	codeStr := code.CodeStr()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.codes[codeStr]; ok {
+		return duplicateCodeError{codeStr: codeStr}
+	}
+	r.codes[codeStr] = code
+	return nil
+}
+
+// Codes gives all the codes in the Registry, keyed by their CodeStr.
+// The returned map is a copy and may be modified.
+func (r *Registry) Codes() map[CodeStr]Code {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	codes := make(map[CodeStr]Code, len(r.codes))
+	for codeStr, code := range r.codes {
+		codes[codeStr] = code
+	}
+	return codes
+}
+
+// Lookup finds a code in the Registry by its full CodeStr.
+// The second return value is false if no code is registered for the CodeStr.
+func (r *Registry) Lookup(codeStr CodeStr) (Code, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	code, ok := r.codes[codeStr]
+	return code, ok
+}
+
+// Merge adds the codes of another Registry to this one.
+// An error is returned for every CodeStr that is in both registries.
+// If there are any errors, no codes are added.
+// Codes do not have numeric representations, so only CodeStr can collide.
+func (r *Registry) Merge(other *Registry) []error {
+	otherCodes := other.Codes()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var errs []error
+	for codeStr := range otherCodes {
+		if _, ok := r.codes[codeStr]; ok {
+			errs = append(errs, duplicateCodeError{codeStr: codeStr})
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].(duplicateCodeError).codeStr < errs[j].(duplicateCodeError).codeStr
+		})
+		return errs
+	}
+	for codeStr, code := range otherCodes {
+		r.codes[codeStr] = code
+	}
+	return nil
+}
+
+// register adds a code to the default registry.
+// Return an error if a code with the same CodeStr is already registered.
+func (code Code) register() error {
+	return registry.Register(code)
+}
+
+// RegisteredCodes gives all the codes created with NewCode or Child, keyed by their CodeStr.
+// The returned map is a copy and may be modified.
+func RegisteredCodes() map[CodeStr]Code {
+	return registry.Codes()
+}
+
+// LookupCode finds a registered code by its full CodeStr.
+// This is useful for reconstructing a Code from a CodeStr received from a client or server.
+// The second return value is false if no code is registered for the CodeStr.
+func LookupCode(codeStr CodeStr) (Code, bool) {
+	return registry.Lookup(codeStr)
+}
+
+// Children gives the registered codes whose Parent is this code.
+// The codes are sorted by CodeStr.
+func (code Code) Children() []Code {
+	codeStr := code.CodeStr()
+	return registeredCodesWhere(func(registered Code) bool {
+		return registered.Parent != nil && registered.Parent.CodeStr() == codeStr
+	})
+}
+
+// Descendants gives all the registered codes that have this code in their Parent chain.
+// The codes are sorted by CodeStr.
+func (code Code) Descendants() []Code {
+	codeStr := code.CodeStr()
+	return registeredCodesWhere(func(registered Code) bool {
+		for parent := registered.Parent; parent != nil; parent = parent.Parent {
+			if parent.CodeStr() == codeStr {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// DeprecatedCodes gives the registered codes that have been marked with Deprecate.
+// The codes are sorted by CodeStr.
+func DeprecatedCodes() []Code {
+	return registeredCodesWhere(Code.IsDeprecated)
+}
+
+// DescribedCodes gives the registered codes that have a Description.
+// The codes are sorted by CodeStr.
+func DescribedCodes() []Code {
+	return registeredCodesWhere(func(code Code) bool { return code.Description() != "" })
+}
+
+// registeredCodesWhere gives the registered codes satisfying the test function, sorted by CodeStr.
+func registeredCodesWhere(test func(Code) bool) []Code {
+	codes := []Code{}
+	for _, registered := range RegisteredCodes() {
+		if test(registered) {
+			codes = append(codes, registered)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].CodeStr() < codes[j].CodeStr()
+	})
+	return codes
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"github.com/pingcap/errors"
+)
+
+// HasRemediation is an interface to retrieve the steps a client can take to recover from an error.
+// Generally the steps should be retrieved with the Remediation function.
+type HasRemediation interface {
+	GetRemediation() []string
+}
+
+// Remediation finds the first HasRemediation in the Causer chain of the error.
+// It returns nil if there are no remediation steps.
+func Remediation(err error) []string {
+	found := errors.Find(err, func(err error) bool {
+		_, ok := err.(HasRemediation)
+		return ok
+	})
+	if found == nil {
+		return nil
+	}
+	return found.(HasRemediation).GetRemediation()
+}
+
+// RemediationErrCode is an ErrorCode with remediation steps attached.
+// This can be constructed with WithRemediation.
+type RemediationErrCode struct {
+	wrappedErrCode
+	Steps []string
+}
+
+// WithRemediation attaches the steps a client can take to recover from an error.
+// The steps are sent to the client: see RemediationClientData.
+func WithRemediation(err ErrorCode, steps []string) ErrorCode {
+	if err == nil {
+		panic("WithRemediation error is nil")
+	}
+	return RemediationErrCode{wrappedErrCode: wrappedErrCode{err}, Steps: steps}
+}
+
+// RemediationClientData is the client data of a RemediationErrCode.
+// Data is the ClientData of the wrapped error.
+type RemediationClientData struct {
+	Data        interface{} `json:"data"`
+	Remediation []string    `json:"remediation"`
+}
+
+// GetRemediation satisfies the HasRemediation interface
+func (e RemediationErrCode) GetRemediation() []string {
+	return e.Steps
+}
+
+// GetClientData returns RemediationClientData.
+func (e RemediationErrCode) GetClientData() interface{} {
+	return RemediationClientData{Data: ClientData(e.Err), Remediation: e.Steps}
+}
+
+var _ ErrorCode = (*RemediationErrCode)(nil)      // assert implements interface
+var _ HasClientData = (*RemediationErrCode)(nil)  // assert implements interface
+var _ HasRemediation = (*RemediationErrCode)(nil) // assert implements interface
@@ -0,0 +1,43 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+// Internal passes through the results of a function call, converting the error with NewInternalErr.
+// The ErrorCode is nil if the error is nil.
+//
+//	user, errCode := errcode.Internal(db.GetUser(id))
+func Internal[T any](v T, err error) (T, ErrorCode) {
+	if err == nil {
+		return v, nil
+	}
+	return v, NewInternalErrDepth(err, 1)
+}
+
+// NotFound passes through the results of a function call, converting the error with NewNotFoundErr.
+// The ErrorCode is nil if the error is nil.
+func NotFound[T any](v T, err error) (T, ErrorCode) {
+	if err == nil {
+		return v, nil
+	}
+	return v, NewNotFoundErr(err)
+}
+
+// InvalidInput passes through the results of a function call, converting the error with NewInvalidInputErr.
+// The ErrorCode is nil if the error is nil.
+func InvalidInput[T any](v T, err error) (T, ErrorCode) {
+	if err == nil {
+		return v, nil
+	}
+	return v, NewInvalidInputErr(err)
+}
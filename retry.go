@@ -0,0 +1,81 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"github.com/pingcap/errors"
+)
+
+// HasMaxRetries is an interface to retrieve the maximum number of retry attempts
+// the server suggests to a client.
+// Generally the value should be retrieved with the MaxRetries function.
+type HasMaxRetries interface {
+	GetMaxRetries() int
+}
+
+// MaxRetries finds the first HasMaxRetries in the Causer chain of the error.
+// The second return value is false if no retry budget was attached.
+func MaxRetries(err error) (int, bool) {
+	found := errors.Find(err, func(err error) bool {
+		_, ok := err.(HasMaxRetries)
+		return ok
+	})
+	if found == nil {
+		return 0, false
+	}
+	return found.(HasMaxRetries).GetMaxRetries(), true
+}
+
+// RetryErrCode is an ErrorCode with a suggested maximum number of retries attached.
+// This can be constructed with WithMaxRetries.
+type RetryErrCode struct {
+	wrappedErrCode
+	MaxRetries int
+}
+
+// WithMaxRetries attaches a retry budget to an ErrorCode.
+// The budget is retrieved with MaxRetries.
+// If the code IsRetryable, the budget is also sent to the client: see RetryClientData.
+func WithMaxRetries(err ErrorCode, n int) ErrorCode {
+	if err == nil {
+		panic("WithMaxRetries error is nil")
+	}
+	return RetryErrCode{wrappedErrCode: wrappedErrCode{err}, MaxRetries: n}
+}
+
+// RetryClientData is the client data of a RetryErrCode with a retryable code.
+// Data is the ClientData of the wrapped error.
+type RetryClientData struct {
+	Data       interface{} `json:"data"`
+	MaxRetries int         `json:"maxRetries"`
+}
+
+// GetMaxRetries satisfies the HasMaxRetries interface
+func (e RetryErrCode) GetMaxRetries() int {
+	return e.MaxRetries
+}
+
+// GetClientData returns RetryClientData if the code is retryable.
+// Otherwise it returns the ClientData of the underlying Err.
+func (e RetryErrCode) GetClientData() interface{} {
+	data := ClientData(e.Err)
+	if !e.Code().IsRetryable() {
+		return data
+	}
+	return RetryClientData{Data: data, MaxRetries: e.MaxRetries}
+}
+
+var _ ErrorCode = (*RetryErrCode)(nil)     // assert implements interface
+var _ HasClientData = (*RetryErrCode)(nil) // assert implements interface
+var _ HasMaxRetries = (*RetryErrCode)(nil) // assert implements interface
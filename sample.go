@@ -0,0 +1,60 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"github.com/pingcap/errors"
+)
+
+// HasForceSample is an interface for errors that should always cause their trace to be sampled.
+// Generally this should be checked with the IsForceSample function.
+type HasForceSample interface {
+	GetForceSample() bool
+}
+
+// IsForceSample looks for a HasForceSample in the Causer chain of the error.
+// Tracing integrations (such as the otel subpackage) use this to sample the trace of important but rare errors.
+func IsForceSample(err error) bool {
+	found := errors.Find(err, func(err error) bool {
+		hasSample, ok := err.(HasForceSample)
+		return ok && hasSample.GetForceSample()
+	})
+	return found != nil
+}
+
+// ForceSampleErrCode is an ErrorCode that should always cause its trace to be sampled.
+// This can be constructed with WithForceSample.
+type ForceSampleErrCode struct {
+	wrappedErrCode
+}
+
+// WithForceSample marks an ErrorCode so that its trace is always sampled.
+// The mark is checked with IsForceSample.
+func WithForceSample(err ErrorCode) ErrorCode {
+	if err == nil {
+		panic("WithForceSample error is nil")
+	}
+	return ForceSampleErrCode{wrappedErrCode: wrappedErrCode{err}}
+}
+
+// GetForceSample satisfies the HasForceSample interface
+func (e ForceSampleErrCode) GetForceSample() bool {
+	return true
+}
+
+// keepsClientData marks that the client data is the client data of the underlying Err.
+func (e ForceSampleErrCode) keepsClientData() {}
+
+var _ ErrorCode = (*ForceSampleErrCode)(nil)      // assert implements interface
+var _ HasForceSample = (*ForceSampleErrCode)(nil) // assert implements interface
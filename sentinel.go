@@ -0,0 +1,63 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+// Sentinel errors for quick returns of the standard codes with a generic message:
+//
+//	return errcode.ErrNotFound
+//
+// A sentinel is an immutable value, so returning it does not share mutable state between callers
+// and two returns of the same sentinel are equal.
+// Errors created by the constructors of this package also match a sentinel with errors.Is when their code is the same:
+// errors.Is(errcode.NewNotFoundErr(err), errcode.ErrNotFound) is true.
+// To match descendants of a code, compare codes with IsAncestor instead.
+var (
+	ErrInternal         ErrorCode = sentinelErr{code: InternalCode, msg: "internal error"}
+	ErrNotFound         ErrorCode = sentinelErr{code: NotFoundCode, msg: "not found"}
+	ErrUnimplemented    ErrorCode = sentinelErr{code: UnimplementedCode, msg: "unimplemented"}
+	ErrAlreadyExists    ErrorCode = sentinelErr{code: AlreadyExistsCode, msg: "already exists"}
+	ErrInvalidInput     ErrorCode = sentinelErr{code: InvalidInputCode, msg: "invalid input"}
+	ErrNotAuthenticated ErrorCode = sentinelErr{code: NotAuthenticatedCode, msg: "not authenticated"}
+	ErrForbidden        ErrorCode = sentinelErr{code: ForbiddenCode, msg: "forbidden"}
+	ErrTimeout          ErrorCode = sentinelErr{code: TimeoutCode, msg: "timeout"}
+	ErrUnavailable      ErrorCode = sentinelErr{code: UnavailableCode, msg: "unavailable"}
+)
+
+// sentinelErr is comparable so that sentinels can be compared with == and errors.Is.
+type sentinelErr struct {
+	code Code
+	msg  string
+}
+
+func (e sentinelErr) Error() string {
+	return e.msg
+}
+
+func (e sentinelErr) Code() Code {
+	return e.code
+}
+
+// GetClientData gives no data: a sentinel only has a code and a message.
+func (e sentinelErr) GetClientData() interface{} {
+	return nil
+}
+
+// isSentinel tells whether the target of errors.Is is a sentinel with the code.
+func isSentinel(code Code, target error) bool {
+	sentinel, ok := target.(sentinelErr)
+	return ok && sentinel.code.Equal(code)
+}
+
+var _ ErrorCode = (*sentinelErr)(nil)     // assert implements interface
+var _ HasClientData = (*sentinelErr)(nil) // assert implements interface
@@ -0,0 +1,71 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import "fmt"
+
+// Severity indicates how serious an error is.
+// It corresponds to a log level.
+// Severities are ordered: a greater value is more severe.
+type Severity int
+
+// The severities from least to most severe.
+const (
+	SeverityDebug Severity = iota
+	SeverityInfo
+	SeverityWarn
+	SeverityError
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityDebug:    "debug",
+	SeverityInfo:     "info",
+	SeverityWarn:     "warn",
+	SeverityError:    "error",
+	SeverityCritical: "critical",
+}
+
+func (severity Severity) String() string {
+	if name, ok := severityNames[severity]; ok {
+		return name
+	}
+	return fmt.Sprintf("Severity(%d)", int(severity))
+}
+
+// MaxSeverity gives the more severe of two severities.
+func MaxSeverity(a, b Severity) Severity {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// ErrorSeverity gives the Severity of the Code of an ErrorCode.
+func ErrorSeverity(errCode ErrorCode) Severity {
+	return errCode.Code().Severity()
+}
+
+// HighestSeverity gives the most severe ErrorSeverity of the errors.
+// This can be used to choose a log level for a group of errors.
+// Nil errors are ignored. If there are no errors SeverityDebug is returned.
+func HighestSeverity(errs []ErrorCode) Severity {
+	highest := SeverityDebug
+	for _, err := range errs {
+		if err != nil {
+			highest = MaxSeverity(highest, ErrorSeverity(err))
+		}
+	}
+	return highest
+}
@@ -0,0 +1,44 @@
+//go:build go1.21
+
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import "log/slog"
+
+// logValue groups the fields of an ErrorCode for log/slog.
+func logValue(errCode ErrorCode) slog.Value {
+	code := errCode.Code()
+	return slog.GroupValue(
+		slog.String("code", code.CodeStr().String()),
+		slog.Int("http_status", code.HTTPStatus()),
+		slog.String("msg", errCode.Error()),
+		slog.Any("data", ClientData(errCode)),
+	)
+}
+
+// LogValue satisfies slog.LogValuer so that logging a CodedError gives a group
+// of the code, http_status, msg, and client data.
+func (e CodedError) LogValue() slog.Value {
+	return logValue(e)
+}
+
+// LogValue satisfies slog.LogValuer so that logging a StackCode gives a group
+// of the code, http_status, msg, and client data.
+func (e StackCode) LogValue() slog.Value {
+	return logValue(e)
+}
+
+var _ slog.LogValuer = (*CodedError)(nil) // assert implements interface
+var _ slog.LogValuer = (*StackCode)(nil)  // assert implements interface
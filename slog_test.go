@@ -0,0 +1,48 @@
+//go:build go1.21
+
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/pingcap/errcode"
+)
+
+func TestSlogLogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	err := errcode.NewCodedError(errors.New("missing"), errcode.NotFoundCode)
+	logger.Error("failed", "err", err)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	// The client data is the wrapped error, which slog logs with its Error string
+	expected := map[string]interface{}{"code": "missing", "http_status": float64(404), "msg": "missing", "data": "missing"}
+	jsonEquals(t, "slog", expected, record["err"])
+
+	buf.Reset()
+	logger.Error("failed", "err", errcode.NewStackCode(err))
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	jsonEquals(t, "slog", expected, record["err"])
+}
@@ -14,6 +14,11 @@
 package errcode
 
 import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+
 	"github.com/pingcap/errors"
 )
 
@@ -38,14 +43,19 @@ type StackCode struct {
 	GetStack errors.StackTracer
 }
 
-// StackTrace fulfills the StackTracer interface
+// StackTrace fulfills the StackTracer interface.
+// It gives the frames recorded by NewStackCode, with the first frame being the innermost call.
+// The StackTrace function should be used to retrieve it from an arbitrary error.
 func (e StackCode) StackTrace() errors.StackTrace {
 	return e.GetStack.StackTrace()
 }
 
 // NewStackCode constructs a StackCode, which is an ErrorCode with stack trace information
 // The second variable is an optional stack position gets rid of information about function calls to construct the stack trace.
-// It is defaulted to 1 to remove this function call.
+// It is defaulted to 1 to remove this function call: the stack starts at the caller of NewStackCode.
+// Each additional position removes one more calling function.
+// For example NewInternalErr uses 3 to remove its helper function and itself,
+// so the stack starts at the caller of NewInternalErr.
 //
 // NewStackCode first looks at the underlying error chain to see if it already has a StackTrace.
 // If so, that StackTrace is used.
@@ -63,14 +73,71 @@ func NewStackCode(err ErrorCode, position ...int) StackCode {
 		return StackCode{Err: err, GetStack: tracer}
 	}
 
+	if depth := atomic.LoadInt32(&stackCaptureDepth); depth > 0 {
+		return StackCode{Err: err, GetStack: newLimitedStack(stackPosition, int(depth))}
+	}
 	return StackCode{Err: err, GetStack: errors.NewStack(stackPosition)}
 }
 
+// stackCaptureDepth is the maximum number of frames recorded by NewStackCode.
+// Zero records the full stack.
+var stackCaptureDepth int32
+
+// SetStackCaptureDepth bounds the number of frames recorded by NewStackCode
+// (and so by NewInternalErr and the other constructors of internal errors).
+// This reduces the cost of frequent internal errors, for example from a flapping dependency.
+// A depth of zero or less restores the default of recording the full stack.
+func SetStackCaptureDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	atomic.StoreInt32(&stackCaptureDepth, int32(depth))
+}
+
+// limitedStack is a stack of program counters with a bounded number of frames.
+type limitedStack []uintptr
+
+// newLimitedStack records at most depth frames.
+// A skip of 0 starts at the caller of newLimitedStack.
+func newLimitedStack(skip int, depth int) limitedStack {
+	pcs := make([]uintptr, depth)
+	n := runtime.Callers(skip+2, pcs)
+	return limitedStack(pcs[:n])
+}
+
+// StackTrace fulfills the StackTracer interface.
+func (s limitedStack) StackTrace() errors.StackTrace {
+	frames := make(errors.StackTrace, len(s))
+	for i, pc := range s {
+		frames[i] = errors.Frame(pc)
+	}
+	return frames
+}
+
+// NewStackCodeDepth is the same as NewStackCode but removes skip more callers from the stack trace.
+// NewStackCodeDepth(err, 0) records a stack starting at its caller just like NewStackCode(err).
+// This is for use in your own constructors:
+// a constructor that calls NewStackCodeDepth(err, 1) records a stack starting at its caller.
+//
+// The error is normalized with CoerceDepth, so an error that wraps an ErrorCode keeps its code
+// and an error without an ErrorCode is given InternalCode.
+func NewStackCodeDepth(err error, skip int) StackCode {
+	if err == nil {
+		panic("NewStackCodeDepth: given error is nil")
+	}
+	return NewStackCode(CoerceDepth(err, 1+skip), 2+skip)
+}
+
 // Cause satisfies the Causer interface
 func (e StackCode) Cause() error {
 	return e.Err
 }
 
+// Unwrap gives Err for errors.Is and errors.As.
+func (e StackCode) Unwrap() error {
+	return e.Err
+}
+
 // Error ignores the stack and gives the underlying Err Error.
 func (e StackCode) Error() string {
 	return e.Err.Error()
@@ -86,6 +153,35 @@ func (e StackCode) GetClientData() interface{} {
 	return ClientData(e.Err)
 }
 
+// Is matches a sentinel error with the same code, such as ErrInternal, for errors.Is.
+func (e StackCode) Is(target error) bool {
+	return isSentinel(e.Code(), target)
+}
+
+// Format implements the Formatter interface.
+// %v and %s give the Error message.
+// %+v gives the Error message followed by the stack trace.
+// This also applies to errors that embed StackCode such as those from NewInternalErr.
+func (e StackCode) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			io.WriteString(s, e.Error())
+			e.StackTrace().Format(s, verb)
+			return
+		}
+		fallthrough
+	case 's':
+		io.WriteString(s, e.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	}
+}
+
+// keepsClientData marks that the client data is the client data of the underlying Err.
+func (e StackCode) keepsClientData() {}
+
 var _ ErrorCode = (*StackCode)(nil)     // assert implements interface
 var _ HasClientData = (*StackCode)(nil) // assert implements interface
 var _ Causer = (*StackCode)(nil)        // assert implements interface
+var _ fmt.Formatter = (*StackCode)(nil) // assert implements interface
@@ -0,0 +1,121 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+var msgTemplateMetaData = make(MetaData)
+
+// SetMsgTemplate adds a user message template to the meta data of a code.
+// Parameters are written in braces, for example "user {id} not found",
+// and are filled in from the client data of an error by RenderMsg.
+// The template can be retrieved with MsgTemplate.
+// Panic if the metadata is already set for the code.
+// Returns itself.
+func (code Code) SetMsgTemplate(tmpl string) Code {
+	if err := code.SetMetaData(msgTemplateMetaData, tmpl); err != nil {
+		panic(errors.Annotate(err, "SetMsgTemplate"))
+	}
+	return code
+}
+
+// MsgTemplate retrieves the user message template of a code.
+// Templates are not inherited from ancestors because the parameters depend on the client data of each code.
+// If none was set for the code it returns an empty string.
+func (code Code) MsgTemplate() string {
+	tmpl, _ := code.GetMetaData(msgTemplateMetaData)
+	if tmpl == nil {
+		return ""
+	}
+	return tmpl.(string)
+}
+
+var msgParam = regexp.MustCompile(`\{(\w+)\}`)
+
+type missingParamsError struct {
+	code   CodeStr
+	params []string
+}
+
+func (e missingParamsError) Error() string {
+	return fmt.Sprintf("message template for code %v is missing parameters: %v", e.code, strings.Join(e.params, ", "))
+}
+
+// RenderMsg renders the MsgTemplate of the code of an ErrorCode.
+// Each parameter is filled in from the ClientData of the error.
+// The client data may be a map with string keys or a struct,
+// in which case the parameter is matched against the JSON name of a field or else the field name.
+// A parameter that is not found is left in place and an error listing the missing parameters is returned.
+// If the code has no template, an empty string is returned.
+func RenderMsg(errCode ErrorCode) (string, error) {
+	tmpl := errCode.Code().MsgTemplate()
+	if tmpl == "" {
+		return "", nil
+	}
+	data := reflect.ValueOf(ClientData(errCode))
+	var missing []string
+	msg := msgParam.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		param := placeholder[1 : len(placeholder)-1]
+		if value, ok := lookupParam(data, param); ok {
+			return fmt.Sprint(value)
+		}
+		missing = append(missing, param)
+		return placeholder
+	})
+	if len(missing) > 0 {
+		return msg, missingParamsError{code: errCode.Code().CodeStr(), params: missing}
+	}
+	return msg, nil
+}
+
+// lookupParam finds a parameter in a map with string keys or a struct.
+func lookupParam(data reflect.Value, param string) (interface{}, bool) {
+	for data.Kind() == reflect.Ptr || data.Kind() == reflect.Interface {
+		if data.IsNil() {
+			return nil, false
+		}
+		data = data.Elem()
+	}
+	switch data.Kind() {
+	case reflect.Map:
+		if data.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		value := data.MapIndex(reflect.ValueOf(param).Convert(data.Type().Key()))
+		if !value.IsValid() {
+			return nil, false
+		}
+		return value.Interface(), true
+	case reflect.Struct:
+		dataType := data.Type()
+		for i := 0; i < dataType.NumField(); i++ {
+			field := dataType.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == param || (name == "" && field.Name == param) {
+				return data.Field(i).Interface(), true
+			}
+		}
+	}
+	return nil, false
+}
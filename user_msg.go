@@ -0,0 +1,79 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"net/http"
+
+	"github.com/pingcap/errors"
+)
+
+// HasUserMsg is an interface to retrieve a message that is safe to show to end users.
+// The Error() message is meant for developers and may contain internal details.
+// Generally the message should be retrieved with the UserMsg function.
+type HasUserMsg interface {
+	GetUserMsg() string
+}
+
+// UserMsg gives the message that should be shown to an end user.
+// It looks for a HasUserMsg in the Causer chain of the ErrorCode.
+// If there is none, it uses the MsgTemplate of the code when all of its parameters can be rendered (see RenderMsg).
+// Otherwise it falls back to Error().
+// A redacted code (see SetRedacted) never renders its client data or uses Error():
+// without a HasUserMsg it gives the HTTP status text of the code.
+// This is used for the Msg field of NewJSONFormat.
+func UserMsg(errCode ErrorCode) string {
+	found := errors.Find(errCode, func(err error) bool {
+		hasMsg, ok := err.(HasUserMsg)
+		return ok && hasMsg.GetUserMsg() != ""
+	})
+	if found != nil {
+		return found.(HasUserMsg).GetUserMsg()
+	}
+	if code := errCode.Code(); code.IsRedacted() {
+		return http.StatusText(code.HTTPStatus())
+	}
+	if msg, err := RenderMsg(errCode); err == nil && msg != "" {
+		return msg
+	}
+	return errCode.Error()
+}
+
+// UserMsgErrCode is an ErrorCode with a user message attached.
+// This can be constructed with WithUserMsg.
+// Error() gives the message of the wrapped error, not the user message.
+type UserMsgErrCode struct {
+	wrappedErrCode
+	UserMsg string
+}
+
+// WithUserMsg attaches a message for end users to an ErrorCode.
+// The code, Error() message, and client data of the ErrorCode are unchanged.
+func WithUserMsg(err ErrorCode, msg string) ErrorCode {
+	if err == nil {
+		panic("WithUserMsg error is nil")
+	}
+	return UserMsgErrCode{wrappedErrCode: wrappedErrCode{err}, UserMsg: msg}
+}
+
+// GetUserMsg satisfies the HasUserMsg interface
+func (e UserMsgErrCode) GetUserMsg() string {
+	return e.UserMsg
+}
+
+// keepsClientData marks that the client data is the client data of the underlying Err.
+func (e UserMsgErrCode) keepsClientData() {}
+
+var _ ErrorCode = (*UserMsgErrCode)(nil)  // assert implements interface
+var _ HasUserMsg = (*UserMsgErrCode)(nil) // assert implements interface
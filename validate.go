@@ -0,0 +1,100 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CodeRule checks that a code has the meta data it requires.
+// It returns an error if the code does not satisfy the rule.
+type CodeRule func(Code) error
+
+type missingMetaDataError struct {
+	code     CodeStr
+	metaData string
+}
+
+func (e missingMetaDataError) Error() string {
+	return fmt.Sprintf("code %v and its ancestors have no %v", e.code, e.metaData)
+}
+
+// RequireMetaData gives a CodeRule that a code or one of its ancestors has the meta data.
+// The name of the meta data is used in the error message.
+func RequireMetaData(metaData MetaData, name string) CodeRule {
+	return func(code Code) error {
+		if code.MetaDataFromAncestors(metaData) == nil {
+			return missingMetaDataError{code: code.CodeStr(), metaData: name}
+		}
+		return nil
+	}
+}
+
+// RequireHTTP is a CodeRule that a code or one of its ancestors has an HTTP code
+// rather than silently defaulting to 400.
+// A code that opted out of HTTP with SetNoHTTP satisfies the rule.
+var RequireHTTP CodeRule = requireHTTP
+
+var requireHTTPMetaData = RequireMetaData(httpMetaData, "HTTP code")
+
+func requireHTTP(code Code) error {
+	if code.MetaDataFromAncestors(noHTTPMetaData) != nil {
+		return nil
+	}
+	return requireHTTPMetaData(code)
+}
+
+// ValidateCodes checks every leaf code of the default registry (a code without children) against the rules.
+// If no rules are given, RequireHTTP is used.
+// This can be run in a test or at startup.
+// See Registry.Validate.
+func ValidateCodes(rules ...CodeRule) []error {
+	return registry.Validate(rules...)
+}
+
+// Validate checks every leaf code of the Registry (a code without children in the Registry) against the rules.
+// If no rules are given, RequireHTTP is used.
+// The errors are ordered by CodeStr and then by rule.
+func (r *Registry) Validate(rules ...CodeRule) []error {
+	if len(rules) == 0 {
+		rules = []CodeRule{RequireHTTP}
+	}
+	codes := r.Codes()
+	parents := make(map[CodeStr]bool)
+	for _, code := range codes {
+		if code.Parent != nil {
+			parents[code.Parent.CodeStr()] = true
+		}
+	}
+	leaves := make([]Code, 0, len(codes))
+	for codeStr, code := range codes {
+		if !parents[codeStr] {
+			leaves = append(leaves, code)
+		}
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return leaves[i].CodeStr() < leaves[j].CodeStr()
+	})
+
+	var errs []error
+	for _, code := range leaves {
+		for _, rule := range rules {
+			if err := rule(code); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"fmt"
+	"io"
+)
+
+// wrappedErrCode is embedded by the wrappers that attach information to an ErrorCode,
+// such as UserMsgErrCode and OriginErrCode.
+// It passes through the Error, Code, client data, and formatting of Err
+// and gives Err to both the Causer interface and errors.Unwrap,
+// so errors.Is and errors.As of the standard library see through the wrapper.
+// A wrapper overrides the methods for whatever it changes.
+type wrappedErrCode struct {
+	Err ErrorCode
+}
+
+// Cause satisfies the Causer interface
+func (e wrappedErrCode) Cause() error {
+	return e.Err
+}
+
+// Unwrap gives Err for errors.Is and errors.As.
+func (e wrappedErrCode) Unwrap() error {
+	return e.Err
+}
+
+// Error gives the underlying Err Error.
+func (e wrappedErrCode) Error() string {
+	return e.Err.Error()
+}
+
+// Code returns the underlying Code of Err.
+func (e wrappedErrCode) Code() Code {
+	return e.Err.Code()
+}
+
+// GetClientData returns the ClientData of the underlying Err.
+func (e wrappedErrCode) GetClientData() interface{} {
+	return ClientData(e.Err)
+}
+
+// Format passes formatting through to Err,
+// so %+v gives the stack trace of an error such as one from NewInternalErr.
+func (e wrappedErrCode) Format(s fmt.State, verb rune) {
+	if formatter, ok := e.Err.(fmt.Formatter); ok {
+		formatter.Format(s, verb)
+		return
+	}
+	switch verb {
+	case 'v', 's':
+		io.WriteString(s, e.Err.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", e.Err.Error())
+	}
+}
+
+var _ ErrorCode = (*wrappedErrCode)(nil)     // assert implements interface
+var _ HasClientData = (*wrappedErrCode)(nil) // assert implements interface
+var _ Causer = (*wrappedErrCode)(nil)        // assert implements interface
+var _ fmt.Formatter = (*wrappedErrCode)(nil) // assert implements interface
@@ -0,0 +1,17 @@
+module github.com/pingcap/errcode/zap
+
+go 1.18
+
+require (
+	github.com/pingcap/errcode v0.0.0
+	go.uber.org/zap v1.23.0
+)
+
+require (
+	github.com/pingcap/errors v0.10.1 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/text v0.7.0 // indirect
+)
+
+replace github.com/pingcap/errcode => ../
@@ -0,0 +1,57 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zap converts error codes to fields for the go.uber.org/zap logger.
+// It is kept separate from the errcode package so that errcode does not depend on zap.
+//
+//	logger.Check(zap.Level(errCode), "request failed").Write(zap.Fields(errCode)...)
+package zap
+
+import (
+	"github.com/pingcap/errcode"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Fields gives the zap fields that should be logged for an ErrorCode.
+// These are the errcode.LogFields (code, msg, http, and when available cause and stack)
+// followed by the errcode.ClientData as data when it is not nil.
+func Fields(errCode errcode.ErrorCode) []zap.Field {
+	logFields := errcode.LogFields(errCode)
+	fields := make([]zap.Field, 0, len(logFields)+1)
+	for _, field := range logFields {
+		fields = append(fields, zap.Any(field.Key, field.Value))
+	}
+	if data := errcode.ClientData(errCode); data != nil {
+		fields = append(fields, zap.Any("data", data))
+	}
+	return fields
+}
+
+// levels maps each errcode.Severity to a zap level.
+// zap has no level between Error and DPanic, so SeverityCritical is also logged at Error.
+var levels = map[errcode.Severity]zapcore.Level{
+	errcode.SeverityDebug:    zapcore.DebugLevel,
+	errcode.SeverityInfo:     zapcore.InfoLevel,
+	errcode.SeverityWarn:     zapcore.WarnLevel,
+	errcode.SeverityError:    zapcore.ErrorLevel,
+	errcode.SeverityCritical: zapcore.ErrorLevel,
+}
+
+// Level gives the zap level for logging an ErrorCode, based on its errcode.ErrorSeverity.
+func Level(errCode errcode.ErrorCode) zapcore.Level {
+	if level, ok := levels[errcode.ErrorSeverity(errCode)]; ok {
+		return level
+	}
+	return zapcore.ErrorLevel
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zap_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/errcode"
+	errzap "github.com/pingcap/errcode/zap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+	err := errcode.NewInternalErr(fmt.Errorf("internal"))
+	logger.Check(errzap.Level(err), "failed").Write(errzap.Fields(err)...)
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one log entry but got %v", len(entries))
+	}
+	if entries[0].Level != zapcore.ErrorLevel {
+		t.Errorf("expected level error but got %v", entries[0].Level)
+	}
+	fields := entries[0].ContextMap()
+	for _, key := range []string{"code", "msg", "http", "cause", "stack", "data"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("expected field %v in %v", key, fields)
+		}
+	}
+	if fields["code"] != "internal" || fields["http"] != int64(500) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	if level := errzap.Level(errcode.NewNotFoundErr(fmt.Errorf("missing"))); level != zapcore.InfoLevel {
+		t.Errorf("expected level info but got %v", level)
+	}
+}